@@ -1,10 +1,12 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/promslog"
 	cron "github.com/robfig/cron/v3"
 	"github.com/truemark/database-collector/exporters/mysql"
@@ -12,8 +14,11 @@ import (
 	"github.com/truemark/database-collector/exporters/postgres"
 	"github.com/truemark/database-collector/internal/aws"
 	"github.com/truemark/database-collector/internal/utils"
+	"io"
 	"log/slog"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -21,29 +26,95 @@ import (
 var (
 	collectors          = make(map[string]map[string]prometheus.Collector) // Store collectors per engine
 	registries          = make(map[string]*prometheus.Registry)            // Store separate registries for each engine
+	targets             = make(map[string]utils.Target)                    // Store identifier and labels per database
 	collectorsMutex     = sync.RWMutex{}                                   // Mutex for safe access
 	secretCheckInterval = 15 * time.Minute                                 // How often to check for new secrets
+	lastDiscovery       time.Time                                          // When secrets were last checked
+	shard               utils.Shard                                        // Part of the fleet this collector scrapes, none until resolved
+	unsupported         = make(map[string]string)                          // Engine of secrets with an unsupported engine
 )
 
 func InitializeCollectors(logger *slog.Logger) {
-	listSecretsResult := aws.ListSecrets()
-	slogLogger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	refreshCollectors(logger)
+}
+
+func RefreshSecrets(logger *slog.Logger) {
+	ticker := time.NewTicker(secretCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		logger.Info("Refreshing secrets and updating collectors...")
+		refreshCollectors(logger)
+	}
+}
 
-	collectorsMutex.Lock() // Lock for safe update
+// refreshCollectors discovers the tagged secrets, registers collectors for new
+// databases and removes those whose secret no longer exists. Only databases
+// of this collector's shard are kept, so they are rebalanced when the shard
+// count changes. Secrets of sources that could not be listed are kept until a
+// listing succeeds, as they may still exist.
+func refreshCollectors(logger *slog.Logger) {
+	listSecretsResult, err := aws.ListSecrets()
+	var listErr *aws.ListSecretsError
+	if err != nil {
+		logger.Warn("Error listing secrets, keeping the collectors of the failed sources", "error", err)
+		errors.As(err, &listErr)
+	}
+	currentShard, err := utils.GetShard()
+
+	collectorsMutex.Lock()
 	defer collectorsMutex.Unlock()
+	lastDiscovery = time.Now()
+
+	if err != nil {
+		// Until a shard has been resolved the collector owns nothing, as
+		// owning everything would make every task scrape every database
+		logger.Warn("Error determining shard, keeping the previous one", "shard", shard.Index, "count", shard.Count, "error", err)
+	} else if !currentShard.Equal(shard) {
+		logger.Info("Shard changed", "shard", currentShard.Index, "count", currentShard.Count)
+		shard = currentShard
+	}
 
+	// Step 1: Track existing database instances of this shard
+	existingSecrets := make(map[string]bool)
 	for _, secretItem := range listSecretsResult.SecretList {
-		secretName := *secretItem.Name
+		if shard.Owns(*secretItem.ARN) {
+			existingSecrets[*secretItem.ARN] = true
+		}
+	}
 
-		secretValue := aws.GetSecretsValue(secretName)
-		secretValueMap := map[string]interface{}{}
-		err := json.Unmarshal([]byte(secretValue), &secretValueMap)
+	// Step 2: Add new secrets
+	for _, secretItem := range listSecretsResult.SecretList {
+		// Secrets are keyed by ARN, names may repeat across accounts and regions
+		secretName := *secretItem.ARN
+		if !existingSecrets[secretName] {
+			continue
+		}
+
+		// Fetch secret, labels are refreshed for existing collectors too
+		secretValueMap, target, err := utils.LoadTarget(secretItem)
 		if err != nil {
-			fmt.Println("Error unmarshalling secret:", err)
+			fmt.Println("Error loading secret:", err)
+			continue
+		}
+		targets[secretName] = target
+
+		// Skip if collector already exists, retry those that failed to initialise
+		if len(collectors[secretName]) > 0 {
 			continue
 		}
 
-		engine := secretValueMap["engine"].(string)
+		// Secrets of unsupported engines are skipped, not reported down, and
+		// only logged when first seen or when the engine changes
+		engine, _ := secretValueMap["engine"].(string)
+		if utils.EngineFamily(engine) == "" {
+			if previous, seen := unsupported[secretName]; !seen || previous != engine {
+				logger.Warn("Unsupported database engine:", "engine", engine, "secretName", secretName)
+			}
+			unsupported[secretName] = engine
+			continue
+		}
+		delete(unsupported, secretName)
 
 		// Ensure each database has its own registry
 		if _, exists := registries[secretName]; !exists {
@@ -55,139 +126,153 @@ func InitializeCollectors(logger *slog.Logger) {
 			collectors[secretName] = make(map[string]prometheus.Collector)
 		}
 
-		// Register new collector for this specific database
+		slogLogger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+		// Register new collector
 		var collector prometheus.Collector
-		switch engine {
+		switch utils.EngineFamily(engine) {
 		case "mysql":
 			collector = mysql.RegisterMySQLCollector(registries[secretName], secretValueMap, slogLogger)
 		case "postgres":
-			collector = postgres.RegisterPostgresCollector(registries[secretName], secretValueMap, slogLogger)
-		case "oracle", "oracle-ee", "custom-oracle-ee":
-			collector = oracle.RegisterOracleDBCollector(registries[secretName], secretValueMap, logger)
-		default:
-			logger.Warn("Unsupported database engine:", "engine", engine)
-			continue
+			collector, err = postgres.RegisterPostgresCollector(registries[secretName], secretValueMap, slogLogger)
+		case "oracle":
+			collector, err = oracle.RegisterOracleDBCollector(registries[secretName], secretValueMap, logger)
 		}
 
 		if err != nil {
-			logger.Warn("Error initializing collector:", "error", err)
+			logger.Warn("Error registering new collector:", "error", err)
 			continue
 		}
 
 		collectors[secretName][engine] = collector
+		logger.Info("Added new collector for: ", "SecretName", secretName)
 	}
-}
-
-func RefreshSecrets(logger *slog.Logger) {
-	ticker := time.NewTicker(secretCheckInterval)
-	defer ticker.Stop()
 
-	for range ticker.C {
-		logger.Info("Refreshing secrets and updating collectors...")
-		listSecretsResult := aws.ListSecrets()
-
-		collectorsMutex.Lock()
-
-		// Step 1: Track existing database instances
-		existingSecrets := make(map[string]bool)
-		for _, secretItem := range listSecretsResult.SecretList {
-			existingSecrets[*secretItem.Name] = true
+	// Step 3: Remove secrets that no longer exist or moved to another shard
+	for secretName := range unsupported {
+		if !existingSecrets[secretName] && !listErr.FailedSecret(secretName) {
+			delete(unsupported, secretName)
 		}
-
-		// Step 2: Add new secrets
-		for _, secretItem := range listSecretsResult.SecretList {
-			secretName := *secretItem.Name
-
-			// Skip if collector already exists
-			if _, exists := collectors[secretName]; exists {
-				continue
-			}
-
-			// Fetch new secret
-			secretValue := aws.GetSecretsValue(secretName)
-			secretValueMap := map[string]interface{}{}
-			err := json.Unmarshal([]byte(secretValue), &secretValueMap)
-			if err != nil {
-				fmt.Println("Error unmarshalling secret:", err)
-				continue
-			}
-
-			engine := secretValueMap["engine"].(string)
-
-			// Ensure each database has its own registry
-			if _, exists := registries[secretName]; !exists {
-				registries[secretName] = prometheus.NewRegistry()
-			}
-
-			// Ensure collectors map exists for this database
-			if _, exists := collectors[secretName]; !exists {
-				collectors[secretName] = make(map[string]prometheus.Collector)
+	}
+	for secretName, dbCollectors := range collectors {
+		if _, found := existingSecrets[secretName]; !found && !listErr.FailedSecret(secretName) {
+			// Unregister all collectors for this database
+			for _, collector := range dbCollectors {
+				registries[secretName].Unregister(collector)
+				// Release connections and files, e.g. an Oracle wallet
+				if closer, ok := collector.(io.Closer); ok {
+					if err := closer.Close(); err != nil {
+						logger.Warn("Error closing collector:", "secretName", secretName, "error", err)
+					}
+				}
 			}
 
-			slogLogger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
-
-			// Register new collector
-			var collector prometheus.Collector
-			switch engine {
-			case "mysql":
-				collector = mysql.RegisterMySQLCollector(registries[secretName], secretValueMap, slogLogger)
-			case "postgres":
-				collector = postgres.RegisterPostgresCollector(registries[secretName], secretValueMap, slogLogger)
-			case "oracle", "oracle-ee", "custom-oracle-ee":
-				collector = oracle.RegisterOracleDBCollector(registries[secretName], secretValueMap, logger)
-			default:
-				logger.Warn("Unsupported database engine:", "engine", engine)
-				continue
-			}
+			// Ensure all running Goroutines for this database are stopped
+			delete(collectors, secretName)
+			delete(registries, secretName)
+			delete(targets, secretName)
 
-			if err != nil {
-				logger.Warn("Error registering new collector:", "error", err)
-				continue
-			}
+			logger.Info("Removed collector for deleted secret:", "secretName", secretName)
+		}
+	}
+}
 
-			collectors[secretName][engine] = collector
-			logger.Info("Added new collector for: ", "SecretName", secretName)
+// collectMetrics gathers and exports the metrics of a target. It returns an
+// error if the target is down or its metrics could not be sent.
+func collectMetrics(collector prometheus.Collector, target utils.Target, logger *slog.Logger, registry *prometheus.Registry) error {
+	// Targets whose collector failed to initialise have no registry and are reported as down
+	var metricFamilies []*dto.MetricFamily
+	var err error
+	if registry != nil {
+		metricFamilies, err = registry.Gather()
+		if err != nil {
+			logger.Error("Error gathering metrics", "identifier", target.Identifier, "error", err)
 		}
+	}
+	up := utils.TargetUp(metricFamilies, target.Engine, err)
+	metricFamilies = append(metricFamilies, utils.TargetUpMetricFamily(up))
 
-		// Step 3: Remove secrets that no longer exist
-		for secretName, dbCollectors := range collectors {
-			if _, found := existingSecrets[secretName]; !found {
-				// Unregister all collectors for this database
-				for _, collector := range dbCollectors {
-					registries[secretName].Unregister(collector)
-				}
+	err = utils.ExportMetrics(metricFamilies, target)
+	if err != nil {
+		logger.Error("Failed to send metrics", "identifier", target.Identifier, "error", err)
+		return err
+	}
+	logger.Info("Successfully sent metrics", "identifier", target.Identifier)
+	if up == 0 {
+		return errors.New("target is down")
+	}
+	return nil
+}
 
-				// Ensure all running Goroutines for this database are stopped
-				delete(collectors, secretName)
-				delete(registries, secretName)
+// TargetResult is the outcome of collecting the metrics of one target.
+type TargetResult struct {
+	Secret     string `json:"secret"`
+	Identifier string `json:"identifier"`
+	Engine     string `json:"engine"`
+	Error      string `json:"error,omitempty"`
+}
 
-				logger.Info("Removed collector for deleted secret:", "secretName", secretName)
-			}
-		}
+// CollectionResult lists the targets scraped and failed in one run.
+type CollectionResult struct {
+	Scraped []TargetResult `json:"scraped"`
+	Failed  []TargetResult `json:"failed"`
+}
 
-		collectorsMutex.Unlock()
-	}
+// CollectionRequest limits a run to some targets, e.g. to shard a large fleet
+// across concurrent Lambda invocations. Targets match secret names, secret
+// ARNs or identifiers. Empty lists match everything.
+type CollectionRequest struct {
+	Targets []string `json:"targets"`
+	Engines []string `json:"engines"`
 }
 
-func collectMetrics(collector prometheus.Collector, secretValueMap map[string]interface{}, logger *slog.Logger, registry *prometheus.Registry, engine string) {
-	metricFamilies, err := registry.Gather()
-	if err != nil {
-		logger.Error("Error gathering metrics", "error", err)
-		return
+// matches reports whether the request includes the target of a secret.
+// Engines match the engine of the target or its family, e.g. oracle matches
+// oracle-ee and custom-oracle-ee.
+func (r CollectionRequest) matches(secretARN string, target utils.Target) bool {
+	if len(r.Engines) > 0 && !slices.Contains(r.Engines, target.Engine) &&
+		!slices.Contains(r.Engines, utils.EngineFamily(target.Engine)) {
+		return false
+	}
+	if len(r.Targets) == 0 {
+		return true
 	}
+	return slices.Contains(r.Targets, secretARN) ||
+		slices.Contains(r.Targets, secretNameFromARN(secretARN)) ||
+		slices.Contains(r.Targets, target.Identifier)
+}
 
-	response, err := utils.ConvertMetricFamilyToTimeSeries(metricFamilies, secretValueMap["host"].(string), engine)
+// secretNameFromARN returns the secret name of a Secrets Manager ARN, which
+// ends in the name followed by a dash and six random characters.
+func secretNameFromARN(secretARN string) string {
+	parsed, err := arn.Parse(secretARN)
 	if err != nil {
-		logger.Error("Failed to send metrics to APS", "error", err)
-	} else {
-		logger.Info("Successfully sent metrics to APS ", "response", response)
+		return secretARN
+	}
+	name := strings.TrimPrefix(parsed.Resource, "secret:")
+	if i := strings.LastIndex(name, "-"); i > 0 && len(name)-i == 7 {
+		name = name[:i]
 	}
+	return name
 }
 
-func HandleRequest(logger *slog.Logger) {
+func HandleRequest(logger *slog.Logger, request CollectionRequest) CollectionResult {
 	logger.Info("Starting database collector")
 
 	var wg sync.WaitGroup
+	var resultMutex sync.Mutex
+	result := CollectionResult{Scraped: []TargetResult{}, Failed: []TargetResult{}}
+	addResult := func(secretName string, target utils.Target, err error) {
+		targetResult := TargetResult{Secret: secretName, Identifier: target.Identifier, Engine: target.Engine}
+		resultMutex.Lock()
+		defer resultMutex.Unlock()
+		if err != nil {
+			targetResult.Error = err.Error()
+			result.Failed = append(result.Failed, targetResult)
+		} else {
+			result.Scraped = append(result.Scraped, targetResult)
+		}
+	}
 
 	collectorsMutex.RLock() // Lock for safe read
 	for secretName, dbCollectors := range collectors {
@@ -197,19 +282,27 @@ func HandleRequest(logger *slog.Logger) {
 		}
 
 		dbRegistry := registries[secretName] // Get the database-specific registry
+		dbTarget := targets[secretName]
+
+		// Skip databases not included in the request
+		if !request.matches(secretName, dbTarget) {
+			continue
+		}
 
-		for engine, collector := range dbCollectors {
+		// Report targets without a working collector as down
+		if len(dbCollectors) == 0 {
 			wg.Add(1)
-			go func(secretName, engine string, collector prometheus.Collector, registry *prometheus.Registry) {
+			go func(secretName string, target utils.Target) {
 				defer wg.Done()
+				addResult(secretName, target, collectMetrics(nil, target, logger, nil))
+			}(secretName, dbTarget)
+			continue
+		}
 
-				// Fetch latest secret value
-				secretValue := aws.GetSecretsValue(secretName)
-				secretValueMap := map[string]interface{}{}
-				if err := json.Unmarshal([]byte(secretValue), &secretValueMap); err != nil {
-					logger.Info("Error parsing secret:", "error", err)
-					return
-				}
+		for _, collector := range dbCollectors {
+			wg.Add(1)
+			go func(secretName string, collector prometheus.Collector, registry *prometheus.Registry, target utils.Target) {
+				defer wg.Done()
 
 				// Ensure the database still exists before collecting metrics
 				collectorsMutex.RLock()
@@ -221,18 +314,31 @@ func HandleRequest(logger *slog.Logger) {
 					return
 				}
 
-				collectMetrics(collector, secretValueMap, logger, registry, engine)
-			}(secretName, engine, collector, dbRegistry)
+				addResult(secretName, target, collectMetrics(collector, target, logger, registry))
+			}(secretName, collector, dbRegistry, dbTarget)
 		}
 	}
 	collectorsMutex.RUnlock() // Unlock after reading
 
 	wg.Wait()
+	return result
 }
 
-func lambdaHandler(logger *slog.Logger) func() {
-	return func() {
-		HandleRequest(logger)
+// lambdaHandler collects the targets of the optional request payload, or
+// every target without one, on each invocation. The goroutine refreshing
+// secrets is frozen between invocations, so discovery is re-run inline once
+// secretCheckInterval has passed. Collectors are kept between warm invocations.
+func lambdaHandler(logger *slog.Logger) func(CollectionRequest) (CollectionResult, error) {
+	return func(request CollectionRequest) (CollectionResult, error) {
+		collectorsMutex.RLock()
+		discoveryExpired := time.Since(lastDiscovery) >= secretCheckInterval
+		collectorsMutex.RUnlock()
+
+		if discoveryExpired {
+			logger.Info("Discovery expired, refreshing secrets and updating collectors...")
+			refreshCollectors(logger)
+		}
+		return HandleRequest(logger, request), nil
 	}
 }
 
@@ -252,18 +358,26 @@ func main() {
 	registries["postgres"] = prometheus.NewRegistry()
 	registries["oracle"] = prometheus.NewRegistry()
 
+	if interval := os.Getenv("DISCOVERY_INTERVAL"); interval != "" {
+		if duration, err := time.ParseDuration(interval); err == nil && duration > 0 {
+			secretCheckInterval = duration
+		} else {
+			logger.Warn("Invalid DISCOVERY_INTERVAL, using default", "value", interval, "default", secretCheckInterval)
+		}
+	}
+
 	// Load initial database collectors
 	InitializeCollectors(logger)
 
-	// Start background secret refresh process
-	go RefreshSecrets(logger) // Runs in a separate goroutine
-
 	if mode == "LAMBDA" {
-		// AWS Lambda Execution
-		lambda.Start(func() { lambdaHandler(logger) })
+		// AWS Lambda Execution, secrets are refreshed by the handler
+		lambda.Start(lambdaHandler(logger))
 	} else if mode == "CRON" {
 		fmt.Println("Starting in CRON mode...")
 
+		// Start background secret refresh process
+		go RefreshSecrets(logger) // Runs in a separate goroutine
+
 		// Run as internal cron job
 		c := cron.New()
 		cronSchedule := os.Getenv("CRON_SCHEDULE")
@@ -271,7 +385,7 @@ func main() {
 			cronSchedule = "@every 5m"
 		}
 		_, err := c.AddFunc(cronSchedule, func() {
-			HandleRequest(logger)
+			HandleRequest(logger, CollectionRequest{})
 		})
 		if err != nil {
 			fmt.Println("Error setting up cron job:", err)
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/truemark/database-collector/internal/utils"
+)
+
+func TestCollectionRequestMatches(t *testing.T) {
+	const secretARN = "arn:aws:secretsmanager:us-west-2:111111111111:secret:prod/orders-db-AbCdEf"
+	oracleEE := utils.Target{Identifier: "orders", Engine: "oracle-ee"}
+	postgres := utils.Target{Identifier: "orders", Engine: "postgres"}
+
+	tests := []struct {
+		name    string
+		request CollectionRequest
+		target  utils.Target
+		want    bool
+	}{
+		{"empty request", CollectionRequest{}, oracleEE, true},
+		{"engine family", CollectionRequest{Engines: []string{"oracle"}}, oracleEE, true},
+		{"exact engine", CollectionRequest{Engines: []string{"oracle-ee"}}, oracleEE, true},
+		{"other engine", CollectionRequest{Engines: []string{"oracle"}}, postgres, false},
+		{"secret name", CollectionRequest{Targets: []string{"prod/orders-db"}}, postgres, true},
+		{"secret ARN", CollectionRequest{Targets: []string{secretARN}}, postgres, true},
+		{"identifier", CollectionRequest{Targets: []string{"orders"}}, postgres, true},
+		{"other target", CollectionRequest{Targets: []string{"billing"}}, postgres, false},
+		{"target and engine", CollectionRequest{Targets: []string{"orders"}, Engines: []string{"mysql"}}, postgres, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.request.matches(secretARN, tt.target); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretNameFromARN(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:secretsmanager:us-west-2:111111111111:secret:prod/orders-db-AbCdEf": "prod/orders-db",
+		"arn:aws:secretsmanager:us-west-2:111111111111:secret:orders":                "orders",
+		"prod/orders-db": "prod/orders-db",
+	}
+	for secretARN, want := range tests {
+		if got := secretNameFromARN(secretARN); got != want {
+			t.Errorf("secretNameFromARN(%q) = %q, want %q", secretARN, got, want)
+		}
+	}
+}
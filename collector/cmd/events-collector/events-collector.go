@@ -1,63 +1,217 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	rdsevents "github.com/truemark/database-collector/internal/events"
 	"github.com/truemark/database-collector/internal/utils"
+	"google.golang.org/protobuf/proto"
 )
 
-type RdsEventMessage struct {
-	EventCategories  []string `json:"EventCategories"`
-	SourceType       string   `json:"SourceType"`
-	SourceArn        string   `json:"SourceArn"`
-	Date             string   `json:"Date"`
-	SourceIdentifier string   `json:"SourceIdentifier"`
-	Message          string   `json:"Message"`
-	EventID          string   `json:"EventID"`
-}
+// EventsCounter counts events of all supported services. Labels are limited
+// to values with a small, fixed set of values; the free-text message is
+// logged instead.
+var EventsCounter = rdsevents.NewCounter(
+	"rds_service_events",
+	"This metric indicates on whats happening on various aws services, e.g RDS",
+	[]string{"event_id", "event_category", "source_type", "source_identifier"},
+)
+
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+var (
+	// catalogue holds the databases discovered from tagged secrets when
+	// EVENTS_TARGET_CATALOGUE is true, nil otherwise
+	catalogue *utils.TargetCatalogue
+	// sourceARNs remembers the ARN of each event source seen by this
+	// container, to tell apart databases with the same identifier in other
+	// regions or accounts
+	sourceARNs = make(map[string]string)
+)
 
-var EventsCounter = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "rds_service_events",
-		Help: "This metric indicates on whats happening on various aws services, e.g RDS",
-	},
-	[]string{"event_id", "event_message", "event_source"},
+// scheduledSource and scheduledDetailType identify the scheduled invocation
+// that pushes the stored state between events.
+const (
+	scheduledSource     = "aws.events"
+	scheduledDetailType = "Scheduled Event"
 )
 
+// identifierLabels are the labels holding the identifier of an event source.
+var identifierLabels = []string{"identifier", "source_identifier"}
+
+// exportMetrics sends the series of all event sources in one write. Each
+// series gets the labels of its source's target, as the database metrics do:
+// with a catalogue, series of known databases get the identifier, engine,
+// region, account and labels of their database; other series get the
+// identifier of their source.
+func exportMetrics(metricFamilies []*dto.MetricFamily) error {
+	targets := make(map[string]utils.Target)
+	for _, mf := range metricFamilies {
+		for _, m := range mf.GetMetric() {
+			identifier := sourceIdentifier(m)
+			target, ok := targets[identifier]
+			if !ok {
+				target = utils.Target{Identifier: identifier}
+				if catalogue != nil {
+					if found, ok := catalogue.Lookup(identifier, sourceARNs[identifier]); ok {
+						target = found
+					}
+				}
+				targets[identifier] = target
+			}
+			addTargetLabels(m, target)
+		}
+	}
+	return utils.ExportMetrics(metricFamilies, utils.Target{})
+}
+
+// addTargetLabels adds the labels of a target to a series, unless the series
+// already has them.
+func addTargetLabels(m *dto.Metric, target utils.Target) {
+	labels := map[string]string{
+		"identifier": target.Identifier,
+		"engine":     target.Engine,
+		"region":     target.Region,
+		"accountId":  target.AccountID,
+	}
+	for name, value := range target.Labels {
+		labels[name] = value
+	}
+	for _, label := range m.GetLabel() {
+		delete(labels, label.GetName())
+	}
+	for name, value := range labels {
+		if value != "" {
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+		}
+	}
+	sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+}
+
+// sourceIdentifier returns the identifier of the event source of a series.
+func sourceIdentifier(m *dto.Metric) string {
+	for _, name := range identifierLabels {
+		for _, label := range m.GetLabel() {
+			if label.GetName() == name {
+				return label.GetValue()
+			}
+		}
+	}
+	return ""
+}
+
 func handler(e events.CloudWatchEvent) {
 	registry := prometheus.NewRegistry()
-	registry.Unregister(prometheus.NewGoCollector())
-	registry.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
 	registry.MustRegister(EventsCounter)
-	event := RdsEventMessage{}
-	print(string(e.Detail))
-	err := json.Unmarshal(e.Detail, &event)
+	registry.MustRegister(rdsevents.Collectors()...)
+	registry.MustRegister(rdsevents.StateCollectors()...)
+	if err := rdsevents.Begin(); err != nil {
+		logger.Warn("Failed to load event state", "error", err)
+	}
+
+	// The scheduled invocation only pushes the stored state
+	if e.Source == scheduledSource && e.DetailType == scheduledDetailType {
+		push(registry)
+		return
+	}
+
+	event, err := rdsevents.Parse(e.Source, e.DetailType, e.Detail, e.Resources, e.Time)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	fmt.Println(event.EventID)
-	if len(event.EventID) == 1 {
-		EventsCounter.WithLabelValues("none", event.Message, event.SourceIdentifier).Inc()
-	} else {
-		EventsCounter.WithLabelValues(event.EventID, event.Message, event.SourceIdentifier).Inc()
+
+	// A repeated delivery of the event was already counted and forwarded
+	first, err := rdsevents.FirstDelivery(e.ID)
+	if err != nil {
+		logger.Warn("Failed to record event delivery", "id", e.ID, "error", err)
+	}
+	if !first {
+		logger.Info("Skipping repeated delivery of event", "id", e.ID)
+		return
+	}
+
+	// Counter samples are stamped with when the event happened, so they line
+	// up with the database metrics, unless a later event was already counted
+	at := event.Time()
+	eventID := event.EventID()
+	sourceType := event.SourceType()
+	identifier := event.Identifier()
+	logger.Info("Service event",
+		"source", e.Source,
+		"event_id", eventID,
+		"event_category", event.Category(),
+		"source_type", sourceType,
+		"source_identifier", identifier,
+		"source_arn", event.SourceArn(),
+		"date", at,
+		"message", event.Message(),
+	)
+	if event.SourceArn() != "" {
+		sourceARNs[identifier] = event.SourceArn()
+	}
+	if err := EventsCounter.Inc(at, eventID, event.Category(), sourceType, identifier); err != nil {
+		logger.Warn("Failed to persist event counter", "error", err)
+	}
+	if err := event.Record(at); err != nil {
+		logger.Warn("Failed to persist source type event counter", "counter", event.CounterName(), "error", err)
 	}
-	print(EventsCounter)
+	if err := event.RecordState(at); err != nil {
+		logger.Warn("Failed to persist event state", "error", err)
+	}
+
+	// Forward the event to the webhooks that select it, with the labels of
+	// its database for routing
+	target := utils.Target{}
+	if catalogue != nil {
+		target, _ = catalogue.Lookup(identifier, event.SourceArn())
+	}
+	if err := rdsevents.Forward(event, at, target); err != nil {
+		logger.Warn("Failed to forward event", "error", err)
+	}
+
+	push(registry)
+}
+
+// push gathers the series changed by the current invocation and exports them.
+func push(registry *prometheus.Registry) {
 	gatherers := prometheus.Gatherers{
 		registry,
 	}
 	metricFamilies, err := gatherers.Gather()
-	response, err := utils.ConvertMetricFamilyToTimeSeries(metricFamilies, event.EventID, "NA")
+	if err != nil {
+		fmt.Println(err, "Failed to gather metrics")
+		return
+	}
+	// Only the counters changed by this invocation and the state are sent,
+	// each carrying the identifier of its source
+	err = exportMetrics(metricFamilies)
 	if err != nil {
 		fmt.Println(err, "Failed to convert metric family to time series")
 	} else {
-		fmt.Println("Successfully sent metrics to APS", response)
+		fmt.Println("Successfully sent metrics")
 	}
 }
 
 func main() {
+	if os.Getenv("EVENTS_TARGET_CATALOGUE") == "true" {
+		interval := 15 * time.Minute
+		if value := os.Getenv("DISCOVERY_INTERVAL"); value != "" {
+			if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+				interval = duration
+			} else {
+				logger.Warn("Invalid DISCOVERY_INTERVAL, using default", "value", value, "default", interval)
+			}
+		}
+		catalogue = utils.NewTargetCatalogue(interval)
+	}
 	lambda.Start(handler)
 }
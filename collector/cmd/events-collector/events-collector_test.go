@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/truemark/database-collector/internal/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+func labelPairs(pairs ...string) []*dto.LabelPair {
+	var labels []*dto.LabelPair
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, &dto.LabelPair{Name: proto.String(pairs[i]), Value: proto.String(pairs[i+1])})
+	}
+	return labels
+}
+
+func TestAddTargetLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []*dto.LabelPair
+		target utils.Target
+		want   map[string]string
+	}{
+		{
+			name:   "source identifier",
+			labels: labelPairs("event_id", "RDS-EVENT-0006", "source_identifier", "orders"),
+			target: utils.Target{Identifier: "orders"},
+			want:   map[string]string{"event_id": "RDS-EVENT-0006", "source_identifier": "orders", "identifier": "orders"},
+		},
+		{
+			name:   "catalogue target",
+			labels: labelPairs("identifier", "orders"),
+			target: utils.Target{Identifier: "orders", Engine: "postgres", Region: "us-east-1", AccountID: "123456789012", Labels: map[string]string{"team": "payments"}},
+			want: map[string]string{"identifier": "orders", "engine": "postgres", "region": "us-east-1",
+				"accountId": "123456789012", "team": "payments"},
+		},
+		{
+			name:   "series labels win",
+			labels: labelPairs("identifier", "orders", "team", "billing"),
+			target: utils.Target{Identifier: "other", Labels: map[string]string{"team": "payments"}},
+			want:   map[string]string{"identifier": "orders", "team": "billing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dto.Metric{Label: tt.labels}
+			addTargetLabels(m, tt.target)
+			got := make(map[string]string)
+			for i, label := range m.GetLabel() {
+				got[label.GetName()] = label.GetValue()
+				if i > 0 && m.Label[i-1].GetName() >= label.GetName() {
+					t.Errorf("labels not sorted: %v", m.GetLabel())
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("labels = %v, want %v", got, tt.want)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Errorf("labels = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
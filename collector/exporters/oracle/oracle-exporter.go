@@ -1,33 +1,161 @@
 package oracle
 
 import (
+	"errors"
 	"fmt"
 	_ "github.com/godror/godror"
 	"github.com/oracle/oracle-db-appdev-monitoring/collector"
 	"github.com/prometheus/client_golang/prometheus"
 	_ "github.com/sijms/go-ora/v2"
 	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
-func RegisterOracleDBCollector(registry *prometheus.Registry, secret map[string]interface{}, logger *slog.Logger) *collector.Exporter {
+// Connection defaults, each can be overridden by the secret field of the
+// same name.
+const (
+	defaultMaxOpenConns = 1
+	defaultMaxIdleConns = 1
+	defaultQueryTimeout = 10
+)
+
+// Collector collects an Oracle database. Close releases its connections and
+// removes its network config directory once it is unregistered.
+type Collector struct {
+	exporter *collector.Exporter
+	// pdbs collects each PDB when pdbMetrics is set, nil otherwise
+	pdbs      *pdbCollector
+	configDir string
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.exporter.Describe(ch)
+	if c.pdbs != nil {
+		c.pdbs.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.Collect(ch)
+	if c.pdbs != nil {
+		c.pdbs.Collect(ch)
+	}
+}
+
+// Close implements io.Closer.
+func (c *Collector) Close() error {
+	var errs []error
+	if c.pdbs != nil {
+		errs = append(errs, c.pdbs.Close())
+	}
+	if c.exporter != nil && c.exporter.GetDB() != nil {
+		errs = append(errs, c.exporter.GetDB().Close())
+	}
+	if c.configDir != "" {
+		errs = append(errs, os.RemoveAll(c.configDir))
+	}
+	return errors.Join(errs...)
+}
+
+func RegisterOracleDBCollector(registry *prometheus.Registry, secret map[string]interface{}, logger *slog.Logger) (*Collector, error) {
 	logger.Info("Registering OracleDB collector")
-	dsn := fmt.Sprintf("%s:%v/%s", secret["host"], secret["port"], secret["dbname"])
+	pdbMode, err := pdbMetricsMode(secret)
+	if err != nil {
+		return nil, err
+	}
+	configDir, err := writeNetworkConfig(secret)
+	if err != nil {
+		return nil, err
+	}
 	config := &collector.Config{
 		User:               secret["username"].(string),
 		Password:           secret["password"].(string),
-		ConnectString:      dsn,
-		MaxOpenConns:       1,
-		MaxIdleConns:       1,
-		QueryTimeout:       10,
+		ConnectString:      connectString(secret),
+		ConfigDir:          configDir,
+		MaxOpenConns:       intSetting(secret, "maxOpenConns", defaultMaxOpenConns),
+		MaxIdleConns:       intSetting(secret, "maxIdleConns", defaultMaxIdleConns),
+		PoolMinConnections: intSetting(secret, "poolMinConnections", 0),
+		PoolMaxConnections: intSetting(secret, "poolMaxConnections", 0),
+		PoolIncrement:      intSetting(secret, "poolIncrement", 0),
+		QueryTimeout:       intSetting(secret, "queryTimeout", defaultQueryTimeout),
 		DefaultMetricsFile: "",
 		CustomMetrics:      "oracle-custom-metrics.toml",
 	}
 
-	oracleExporter, err := collector.NewExporter(logger, config)
+	oracleCollector := &Collector{configDir: configDir}
+	oracleCollector.exporter, err = collector.NewExporter(logger, config)
 	if err != nil {
-		logger.Error("unable to connect to DB", "error", err)
+		oracleCollector.Close()
+		return nil, fmt.Errorf("unable to connect to DB: %w", err)
 	}
 
-	registry.MustRegister(oracleExporter)
-	return oracleExporter
+	// Per-PDB metrics of a multitenant container database
+	if pdbMode != "" {
+		oracleCollector.pdbs = newPDBCollector(secret, config, pdbMode, logger)
+	}
+
+	if err := registry.Register(oracleCollector); err != nil {
+		oracleCollector.Close()
+		return nil, err
+	}
+	return oracleCollector, nil
+}
+
+// connectString returns the connectString secret field when set, e.g. a full
+// TNS descriptor or an alias from the wallet's tnsnames.ora. Otherwise it is
+// built from host, port and dbname: a TCPS descriptor when protocol is tcps,
+// an EZConnect string when not.
+func connectString(secret map[string]interface{}) string {
+	if value := stringSetting(secret, "connectString"); value != "" {
+		return value
+	}
+	return serviceConnectString(secret, fmt.Sprint(secret["dbname"]))
+}
+
+var serviceNamePattern = regexp.MustCompile(`(?i)\(\s*SERVICE_NAME\s*=\s*[^)]*\)`)
+
+// serviceConnectString returns the connect string of another service of the
+// same database, e.g. of a PDB. The service name of a connectString TNS
+// descriptor is replaced, otherwise the string is built from host and port.
+func serviceConnectString(secret map[string]interface{}, service string) string {
+	if value := stringSetting(secret, "connectString"); serviceNamePattern.MatchString(value) {
+		return serviceNamePattern.ReplaceAllLiteralString(value, "(SERVICE_NAME="+service+")")
+	}
+	if !strings.EqualFold(stringSetting(secret, "protocol"), "tcps") {
+		return fmt.Sprintf("%s:%v/%s", secret["host"], secret["port"], service)
+	}
+
+	security := ""
+	if dn := stringSetting(secret, "sslServerCertDn"); dn != "" {
+		security = fmt.Sprintf("(SECURITY=(SSL_SERVER_DN_MATCH=yes)(SSL_SERVER_CERT_DN=\"%s\"))", dn)
+	}
+	return fmt.Sprintf("(DESCRIPTION=(ADDRESS=(PROTOCOL=TCPS)(HOST=%s)(PORT=%v))(CONNECT_DATA=(SERVICE_NAME=%s))%s)",
+		secret["host"], secret["port"], service, security)
+}
+
+// stringSetting returns a string field of the secret, or "" if it is missing.
+func stringSetting(secret map[string]interface{}, key string) string {
+	if value, ok := secret[key].(string); ok {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
+
+// intSetting returns a numeric field of the secret, which may be stored as a
+// JSON number or a string, or defaultValue if it is missing or invalid.
+func intSetting(secret map[string]interface{}, key string, defaultValue int) int {
+	switch value := secret[key].(type) {
+	case float64:
+		return int(value)
+	case string:
+		if parsed, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
 }
@@ -0,0 +1,83 @@
+package oracle
+
+import "testing"
+
+func TestConnectString(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret map[string]interface{}
+		want   string
+	}{
+		{
+			name:   "ezconnect",
+			secret: map[string]interface{}{"host": "orders.abc123.us-east-1.rds.amazonaws.com", "port": float64(1521), "dbname": "ORCL"},
+			want:   "orders.abc123.us-east-1.rds.amazonaws.com:1521/ORCL",
+		},
+		{
+			name:   "string port",
+			secret: map[string]interface{}{"host": "db.example.com", "port": "1522", "dbname": "ORCL", "protocol": "tcp"},
+			want:   "db.example.com:1522/ORCL",
+		},
+		{
+			name:   "tcps",
+			secret: map[string]interface{}{"host": "db.example.com", "port": float64(2484), "dbname": "ORCL", "protocol": "TCPS"},
+			want:   "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCPS)(HOST=db.example.com)(PORT=2484))(CONNECT_DATA=(SERVICE_NAME=ORCL)))",
+		},
+		{
+			name: "tcps with server certificate dn",
+			secret: map[string]interface{}{"host": "db.example.com", "port": float64(2484), "dbname": "ORCL", "protocol": "tcps",
+				"sslServerCertDn": "CN=db.example.com"},
+			want: "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCPS)(HOST=db.example.com)(PORT=2484))(CONNECT_DATA=(SERVICE_NAME=ORCL))" +
+				"(SECURITY=(SSL_SERVER_DN_MATCH=yes)(SSL_SERVER_CERT_DN=\"CN=db.example.com\")))",
+		},
+		{
+			name:   "connect string",
+			secret: map[string]interface{}{"host": "db.example.com", "port": float64(1521), "dbname": "ORCL", "connectString": " orders_high "},
+			want:   "orders_high",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := connectString(tt.secret); got != tt.want {
+				t.Errorf("connectString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceConnectString(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret map[string]interface{}
+		want   string
+	}{
+		{
+			name:   "ezconnect",
+			secret: map[string]interface{}{"host": "db.example.com", "port": float64(1521), "dbname": "ORCL"},
+			want:   "db.example.com:1521/orders",
+		},
+		{
+			name:   "tcps",
+			secret: map[string]interface{}{"host": "db.example.com", "port": float64(2484), "dbname": "ORCL", "protocol": "tcps"},
+			want:   "(DESCRIPTION=(ADDRESS=(PROTOCOL=TCPS)(HOST=db.example.com)(PORT=2484))(CONNECT_DATA=(SERVICE_NAME=orders)))",
+		},
+		{
+			name: "descriptor",
+			secret: map[string]interface{}{"connectString": "(DESCRIPTION=(ADDRESS_LIST=(ADDRESS=(PROTOCOL=TCP)(HOST=a)(PORT=1521)))" +
+				"(CONNECT_DATA=( service_name = ORCL )))"},
+			want: "(DESCRIPTION=(ADDRESS_LIST=(ADDRESS=(PROTOCOL=TCP)(HOST=a)(PORT=1521)))(CONNECT_DATA=(SERVICE_NAME=orders)))",
+		},
+		{
+			name:   "alias falls back to host",
+			secret: map[string]interface{}{"connectString": "orcl_high", "host": "db.example.com", "port": "1521"},
+			want:   "db.example.com:1521/orders",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serviceConnectString(tt.secret, "orders"); got != tt.want {
+				t.Errorf("serviceConnectString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package oracle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godror/godror"
+	"github.com/oracle/oracle-db-appdev-monitoring/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PDB collection modes, set by the pdbMetrics secret field.
+const (
+	// pdbModeCDB collects every PDB through the connection to CDB$ROOT
+	pdbModeCDB = "cdb"
+	// pdbModeServices connects to the service of each PDB, listed in the
+	// pdbServices secret field or otherwise found in v$services of CDB$ROOT
+	pdbModeServices = "services"
+)
+
+// pdbMetricsMode returns the PDB collection mode set by the pdbMetrics secret
+// field, "" when unset, or an error when it is not supported.
+func pdbMetricsMode(secret map[string]interface{}) (string, error) {
+	switch mode := strings.ToLower(stringSetting(secret, "pdbMetrics")); mode {
+	case "", pdbModeCDB, pdbModeServices:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported pdbMetrics mode %q, use %s or %s", mode, pdbModeCDB, pdbModeServices)
+	}
+}
+
+// The queries work both in CDB$ROOT, where v$pdbs and the CDB_ views cover
+// every PDB, and in a PDB, where they only cover the PDB itself. The seed PDB
+// is a read-only template and left out.
+const (
+	pdbsQuery = `SELECT name, open_mode FROM v$pdbs WHERE name <> 'PDB$SEED'`
+
+	pdbServicesQuery = `SELECT p.name, s.network_name
+		FROM v$services s JOIN v$pdbs p ON s.con_id = p.con_id
+		WHERE s.network_name IS NOT NULL AND p.name <> 'PDB$SEED'`
+
+	pdbSessionsQuery = `SELECT p.name, s.status, s.type, COUNT(*)
+		FROM v$session s JOIN v$pdbs p ON s.con_id = p.con_id
+		WHERE p.name <> 'PDB$SEED'
+		GROUP BY p.name, s.status, s.type`
+
+	pdbTablespaceQuery = `SELECT p.name, m.tablespace_name, t.contents,
+			m.used_space * t.block_size, m.tablespace_size * t.block_size, m.used_percent
+		FROM cdb_tablespace_usage_metrics m
+		JOIN cdb_tablespaces t ON m.con_id = t.con_id AND m.tablespace_name = t.tablespace_name
+		JOIN v$pdbs p ON m.con_id = p.con_id
+		WHERE p.name <> 'PDB$SEED'`
+
+	pdbWaitClassQuery = `SELECT p.name, n.wait_class, SUM(e.total_waits), SUM(e.time_waited_micro) / 1000000
+		FROM v$con_system_event e
+		JOIN v$event_name n ON e.event_id = n.event_id
+		JOIN v$pdbs p ON e.con_id = p.con_id
+		WHERE n.wait_class <> 'Idle' AND p.name <> 'PDB$SEED'
+		GROUP BY p.name, n.wait_class`
+)
+
+var (
+	pdbUpDesc = prometheus.NewDesc("oracledb_pdb_up",
+		"Whether the PDB is open (1) or not (0).", []string{"pdb", "open_mode"}, nil)
+	pdbSessionsDesc = prometheus.NewDesc("oracledb_pdb_sessions",
+		"Number of sessions of the PDB.", []string{"pdb", "status", "type"}, nil)
+	pdbTablespaceBytesDesc = prometheus.NewDesc("oracledb_pdb_tablespace_bytes",
+		"Used space of the tablespace of the PDB.", []string{"pdb", "tablespace", "type"}, nil)
+	pdbTablespaceMaxBytesDesc = prometheus.NewDesc("oracledb_pdb_tablespace_max_bytes",
+		"Maximum size of the tablespace of the PDB, including autoextension.", []string{"pdb", "tablespace", "type"}, nil)
+	pdbTablespaceUsedPercentDesc = prometheus.NewDesc("oracledb_pdb_tablespace_used_percent",
+		"Used space of the tablespace of the PDB as a percentage of its maximum size.", []string{"pdb", "tablespace", "type"}, nil)
+	pdbWaitsDesc = prometheus.NewDesc("oracledb_pdb_waits_total",
+		"Number of waits of the PDB per wait class.", []string{"pdb", "wait_class"}, nil)
+	pdbWaitTimeDesc = prometheus.NewDesc("oracledb_pdb_wait_time_seconds_total",
+		"Time waited by the PDB per wait class.", []string{"pdb", "wait_class"}, nil)
+)
+
+// pdbCollector collects tablespace usage, sessions and wait classes of each
+// PDB of a container database, with a pdb label.
+type pdbCollector struct {
+	secret       map[string]interface{}
+	config       *collector.Config
+	mode         string
+	queryTimeout time.Duration
+	logger       *slog.Logger
+	cdb          *sql.DB
+
+	mutex sync.Mutex
+	// services holds the connection to each PDB service in services mode
+	services map[string]*sql.DB
+}
+
+// newPDBCollector returns a collector of the PDBs in the given mode, which
+// must be valid, see pdbMetricsMode.
+func newPDBCollector(secret map[string]interface{}, config *collector.Config, mode string, logger *slog.Logger) *pdbCollector {
+	queryTimeout := time.Duration(config.QueryTimeout) * time.Second
+	if queryTimeout <= 0 {
+		queryTimeout = defaultQueryTimeout * time.Second
+	}
+	return &pdbCollector{
+		secret:       secret,
+		config:       config,
+		mode:         mode,
+		queryTimeout: queryTimeout,
+		logger:       logger,
+		cdb:          openDB(config, config.ConnectString),
+		services:     make(map[string]*sql.DB),
+	}
+}
+
+// Close closes the connections to CDB$ROOT and to each PDB service.
+func (c *pdbCollector) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	errs := []error{c.cdb.Close()}
+	for service, db := range c.services {
+		errs = append(errs, db.Close())
+		delete(c.services, service)
+	}
+	return errors.Join(errs...)
+}
+
+// openDB configures a connection to the database like the exporter does,
+// with the given connect string. It does not connect until first used.
+func openDB(config *collector.Config, connectString string) *sql.DB {
+	var params godror.ConnectionParams
+	params.Username, params.Password, params.ConnectString = config.User, godror.NewPassword(config.Password), connectString
+	params.ExternalAuth = sql.NullBool{Bool: config.Password == "", Valid: true}
+	if params.ExternalAuth.Bool {
+		params.Username = ""
+	}
+	params.ConfigDir = config.ConfigDir
+	params.PoolParams.WaitTimeout = 5 * time.Second
+
+	db := sql.OpenDB(godror.NewConnector(params))
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	return db
+}
+
+// Describe implements prometheus.Collector.
+func (c *pdbCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{pdbUpDesc, pdbSessionsDesc, pdbTablespaceBytesDesc,
+		pdbTablespaceMaxBytesDesc, pdbTablespaceUsedPercentDesc, pdbWaitsDesc, pdbWaitTimeDesc} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector. Failing queries are logged and
+// leave out their metrics, so one closed PDB does not fail the target.
+func (c *pdbCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.mode == pdbModeCDB {
+		c.collect(c.cdb, ch)
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	services, err := c.pdbServices()
+	if err != nil {
+		c.logger.Error("Error listing PDB services", "error", err)
+		return
+	}
+	for pdb, db := range c.services {
+		if _, ok := services[pdb]; !ok {
+			db.Close()
+			delete(c.services, pdb)
+		}
+	}
+	for pdb, service := range services {
+		db, ok := c.services[pdb]
+		if !ok {
+			db = openDB(c.config, serviceConnectString(c.secret, service))
+			c.services[pdb] = db
+		}
+		c.collect(db, ch)
+	}
+}
+
+// pdbServices returns the service to connect to for each PDB. The services
+// listed in the comma separated pdbServices secret field are used as they
+// are, so a user local to each PDB needs no access to CDB$ROOT. Otherwise the
+// first service of each PDB is found in v$services of CDB$ROOT, which needs a
+// common user.
+func (c *pdbCollector) pdbServices() (map[string]string, error) {
+	services := make(map[string]string)
+	if listed := stringSetting(c.secret, "pdbServices"); listed != "" {
+		for _, service := range strings.Split(listed, ",") {
+			if service = strings.TrimSpace(service); service != "" {
+				services[service] = service
+			}
+		}
+		return services, nil
+	}
+
+	err := c.query(c.cdb, pdbServicesQuery, func(rows *sql.Rows) error {
+		var pdb, service string
+		if err := rows.Scan(&pdb, &service); err != nil {
+			return err
+		}
+		if _, ok := services[pdb]; !ok {
+			services[pdb] = service
+		}
+		return nil
+	})
+	return services, err
+}
+
+// collect runs the PDB queries on a connection to CDB$ROOT or to a PDB.
+func (c *pdbCollector) collect(db *sql.DB, ch chan<- prometheus.Metric) {
+	queries := []struct {
+		query string
+		scan  func(rows *sql.Rows) error
+	}{
+		{pdbsQuery, func(rows *sql.Rows) error {
+			var pdb, openMode string
+			if err := rows.Scan(&pdb, &openMode); err != nil {
+				return err
+			}
+			up := 0.0
+			if strings.HasPrefix(openMode, "READ") {
+				up = 1
+			}
+			ch <- prometheus.MustNewConstMetric(pdbUpDesc, prometheus.GaugeValue, up, pdb, openMode)
+			return nil
+		}},
+		{pdbSessionsQuery, func(rows *sql.Rows) error {
+			var pdb, status, sessionType string
+			var count float64
+			if err := rows.Scan(&pdb, &status, &sessionType, &count); err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(pdbSessionsDesc, prometheus.GaugeValue, count, pdb, strings.ToLower(status), strings.ToLower(sessionType))
+			return nil
+		}},
+		{pdbTablespaceQuery, func(rows *sql.Rows) error {
+			var pdb, tablespace, contents string
+			var used, size, usedPercent float64
+			if err := rows.Scan(&pdb, &tablespace, &contents, &used, &size, &usedPercent); err != nil {
+				return err
+			}
+			contents = strings.ToLower(contents)
+			ch <- prometheus.MustNewConstMetric(pdbTablespaceBytesDesc, prometheus.GaugeValue, used, pdb, tablespace, contents)
+			ch <- prometheus.MustNewConstMetric(pdbTablespaceMaxBytesDesc, prometheus.GaugeValue, size, pdb, tablespace, contents)
+			ch <- prometheus.MustNewConstMetric(pdbTablespaceUsedPercentDesc, prometheus.GaugeValue, usedPercent, pdb, tablespace, contents)
+			return nil
+		}},
+		{pdbWaitClassQuery, func(rows *sql.Rows) error {
+			var pdb, waitClass string
+			var waits, seconds float64
+			if err := rows.Scan(&pdb, &waitClass, &waits, &seconds); err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(pdbWaitsDesc, prometheus.CounterValue, waits, pdb, waitClass)
+			ch <- prometheus.MustNewConstMetric(pdbWaitTimeDesc, prometheus.CounterValue, seconds, pdb, waitClass)
+			return nil
+		}},
+	}
+	for _, q := range queries {
+		if err := c.query(db, q.query, q.scan); err != nil {
+			c.logger.Error("Error collecting PDB metrics", "error", err)
+		}
+	}
+}
+
+// query runs a query within the query timeout and scans each row.
+func (c *pdbCollector) query(db *sql.DB, query string, scan func(rows *sql.Rows) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.queryTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
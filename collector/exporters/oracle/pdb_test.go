@@ -0,0 +1,46 @@
+package oracle
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/oracle/oracle-db-appdev-monitoring/collector"
+)
+
+func TestPDBMetricsMode(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{value: nil, want: ""},
+		{value: "cdb", want: pdbModeCDB},
+		{value: " Services ", want: pdbModeServices},
+		{value: "all", wantErr: true},
+	}
+	for _, tt := range tests {
+		secret := map[string]interface{}{}
+		if tt.value != nil {
+			secret["pdbMetrics"] = tt.value
+		}
+		got, err := pdbMetricsMode(secret)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("pdbMetricsMode(%v) = %q, %v, want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPDBServicesListed(t *testing.T) {
+	secret := map[string]interface{}{"host": "db.example.com", "port": float64(1521), "pdbServices": " orders, billing,,"}
+	pdbs := newPDBCollector(secret, &collector.Config{ConnectString: "db.example.com:1521/ORCL"}, pdbModeServices, slog.Default())
+	defer pdbs.Close()
+
+	// listed services are used without querying CDB$ROOT
+	services, err := pdbs.pdbServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 || services["orders"] != "orders" || services["billing"] != "billing" {
+		t.Errorf("pdbServices() = %v, want orders and billing", services)
+	}
+}
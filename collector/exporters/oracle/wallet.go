@@ -0,0 +1,118 @@
+package oracle
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/truemark/database-collector/internal/aws"
+)
+
+// walletFile is the name a wallet is stored under when it is not a zip of
+// wallet files, i.e. an auto-login wallet.
+const walletFile = "cwallet.sso"
+
+// writeNetworkConfig writes the wallet and sqlnet.ora of a target to a new
+// directory and returns it, for use as the client's TNS_ADMIN. The wallet is
+// read from the base64 wallet secret field or from the S3 object named by
+// walletS3Uri, either as an auto-login cwallet.sso or as a zip of wallet
+// files, which may include a tnsnames.ora. networkEncryption sets the native
+// network encryption and checksumming level, e.g. REQUIRED. It returns ""
+// when the target needs neither. The directory is removed if it cannot be
+// written, otherwise by Collector.Close.
+func writeNetworkConfig(secret map[string]interface{}) (_ string, err error) {
+	wallet, err := readWallet(secret)
+	if err != nil {
+		return "", err
+	}
+	encryption := strings.ToUpper(stringSetting(secret, "networkEncryption"))
+	if wallet == nil && encryption == "" {
+		return "", nil
+	}
+
+	dir, err := os.MkdirTemp("", "oracle-network-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create oracle network config directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	if wallet != nil {
+		if err := extractWallet(wallet, dir); err != nil {
+			return "", err
+		}
+	}
+
+	// sqlnet.ora of a downloaded wallet points at its install location,
+	// so it is replaced
+	var sqlnet strings.Builder
+	if wallet != nil {
+		fmt.Fprintf(&sqlnet, "WALLET_LOCATION = (SOURCE = (METHOD = file) (METHOD_DATA = (DIRECTORY = \"%s\")))\n", dir)
+		dnMatch := "no"
+		if stringSetting(secret, "sslServerCertDn") != "" {
+			dnMatch = "yes"
+		}
+		fmt.Fprintf(&sqlnet, "SSL_SERVER_DN_MATCH = %s\n", dnMatch)
+	}
+	if encryption != "" {
+		fmt.Fprintf(&sqlnet, "SQLNET.ENCRYPTION_CLIENT = %s\n", encryption)
+		fmt.Fprintf(&sqlnet, "SQLNET.CRYPTO_CHECKSUM_CLIENT = %s\n", encryption)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sqlnet.ora"), []byte(sqlnet.String()), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write sqlnet.ora: %w", err)
+	}
+	return dir, nil
+}
+
+// readWallet returns the wallet of the target, or nil if it has none.
+func readWallet(secret map[string]interface{}) ([]byte, error) {
+	if value := stringSetting(secret, "wallet"); value != "" {
+		wallet, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 wallet: %w", err)
+		}
+		return wallet, nil
+	}
+	if uri := stringSetting(secret, "walletS3Uri"); uri != "" {
+		return aws.GetS3Object(uri)
+	}
+	return nil, nil
+}
+
+// extractWallet writes the wallet files to dir. Paths inside a zip are
+// ignored, wallet files are always stored at the top of the directory.
+func extractWallet(wallet []byte, dir string) error {
+	archive, err := zip.NewReader(bytes.NewReader(wallet), int64(len(wallet)))
+	if err != nil {
+		return os.WriteFile(filepath.Join(dir, walletFile), wallet, 0o600)
+	}
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if err := extractFile(file, filepath.Join(dir, filepath.Base(file.Name))); err != nil {
+			return fmt.Errorf("failed to extract wallet file %s: %w", file.Name, err)
+		}
+	}
+	return nil
+}
+
+func extractFile(file *zip.File, path string) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
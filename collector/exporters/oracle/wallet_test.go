@@ -0,0 +1,137 @@
+package oracle
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// walletZip returns a zip of the given wallet files. When corrupt is set the
+// checksum of the last file is wrong, so it fails to extract.
+func walletZip(t *testing.T, corrupt bool, files map[string]string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for name, content := range files {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	if corrupt {
+		// the stored file data starts after its 30 byte header and name
+		for name := range files {
+			data[30+len(name)] ^= 0xff
+			break
+		}
+	}
+	return data
+}
+
+func TestWriteNetworkConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		secret     map[string]interface{}
+		wantDir    bool
+		wantErr    bool
+		wantFiles  []string
+		wantSqlnet []string
+	}{
+		{
+			name:   "no wallet or encryption",
+			secret: map[string]interface{}{},
+		},
+		{
+			name:       "encryption only",
+			secret:     map[string]interface{}{"networkEncryption": "required"},
+			wantDir:    true,
+			wantFiles:  []string{"sqlnet.ora"},
+			wantSqlnet: []string{"SQLNET.ENCRYPTION_CLIENT = REQUIRED"},
+		},
+		{
+			name:       "auto-login wallet",
+			secret:     map[string]interface{}{"wallet": base64.StdEncoding.EncodeToString([]byte("sso")), "sslServerCertDn": "CN=db"},
+			wantDir:    true,
+			wantFiles:  []string{"cwallet.sso", "sqlnet.ora"},
+			wantSqlnet: []string{"WALLET_LOCATION", "SSL_SERVER_DN_MATCH = yes"},
+		},
+		{
+			name: "zip wallet",
+			secret: map[string]interface{}{"wallet": base64.StdEncoding.EncodeToString(walletZip(t, false,
+				map[string]string{"wallet/cwallet.sso": "sso", "wallet/tnsnames.ora": "orders_high = (DESCRIPTION=)"}))},
+			wantDir:    true,
+			wantFiles:  []string{"cwallet.sso", "sqlnet.ora", "tnsnames.ora"},
+			wantSqlnet: []string{"SSL_SERVER_DN_MATCH = no"},
+		},
+		{
+			name:    "invalid base64",
+			secret:  map[string]interface{}{"wallet": "not base64!"},
+			wantErr: true,
+		},
+		{
+			name: "corrupt zip",
+			secret: map[string]interface{}{"wallet": base64.StdEncoding.EncodeToString(walletZip(t, true,
+				map[string]string{"cwallet.sso": "sso"}))},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+
+			dir, err := writeNetworkConfig(tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeNetworkConfig() error = %v, want error %v", err, tt.wantErr)
+			}
+			if (dir != "") != tt.wantDir {
+				t.Fatalf("writeNetworkConfig() = %q, want a directory %v", dir, tt.wantDir)
+			}
+			if !tt.wantDir {
+				// nothing is left behind, also when writing fails
+				if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+					t.Errorf("left %d entries in %s", len(entries), tmp)
+				}
+				return
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for _, entry := range entries {
+				files = append(files, entry.Name())
+			}
+			if strings.Join(files, ",") != strings.Join(tt.wantFiles, ",") {
+				t.Errorf("files = %v, want %v", files, tt.wantFiles)
+			}
+			sqlnet, err := os.ReadFile(filepath.Join(dir, "sqlnet.ora"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.wantSqlnet {
+				if !strings.Contains(string(sqlnet), want) {
+					t.Errorf("sqlnet.ora = %q, want %q", sqlnet, want)
+				}
+			}
+
+			// Close removes the directory of an unregistered collector
+			if err := (&Collector{configDir: dir}).Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Errorf("Close() left %s", dir)
+			}
+		})
+	}
+}
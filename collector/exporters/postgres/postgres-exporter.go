@@ -11,7 +11,7 @@ import (
 	"github.com/prometheus/common/promslog/flag"
 )
 
-func RegisterPostgresCollector(registry *prometheus.Registry, secret map[string]interface{}, logger *slog.Logger) *collector.PostgresCollector {
+func RegisterPostgresCollector(registry *prometheus.Registry, secret map[string]interface{}, logger *slog.Logger) (*collector.PostgresCollector, error) {
 	logger.Info("Registering Postgres collector")
 	promlogConfig := &promslog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
@@ -26,9 +26,8 @@ func RegisterPostgresCollector(registry *prometheus.Registry, secret map[string]
 		[]string{}, // no exclude databases
 	)
 	if err != nil {
-		fmt.Println("msg", "Failed to create PostgresCollector", "err", err.Error())
-	} else {
-		registry.MustRegister(pgCollector)
+		return nil, fmt.Errorf("failed to create PostgresCollector: %w", err)
 	}
-	return pgCollector
+	registry.MustRegister(pgCollector)
+	return pgCollector, nil
 }
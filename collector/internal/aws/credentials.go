@@ -0,0 +1,26 @@
+package aws
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+)
+
+// assumeRoleSessionName identifies the collector in the assumed role's
+// CloudTrail entries.
+const assumeRoleSessionName = "database-collector"
+
+// AssumeRoleCredentials returns credentials for roleARN, assumed with the
+// optional externalID. The credentials are cached and refreshed shortly
+// before they expire.
+func AssumeRoleCredentials(sess client.ConfigProvider, roleARN string, externalID string) *credentials.Credentials {
+	return stscreds.NewCredentials(sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = assumeRoleSessionName
+		p.ExpiryWindow = time.Minute
+		if externalID != "" {
+			p.ExternalID = &externalID
+		}
+	})
+}
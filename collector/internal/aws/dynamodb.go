@@ -0,0 +1,193 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var dynamodbService *dynamodb.DynamoDB
+
+func getDynamoDBService() *dynamodb.DynamoDB {
+	if dynamodbService == nil {
+		sess := session.Must(session.NewSession())
+		dynamodbService = dynamodb.New(sess, aws.NewConfig().WithRegion(GetRegion()))
+	}
+	return dynamodbService
+}
+
+// IncrementCounter atomically adds delta to the counter stored under id in
+// table for an event at the given time, and returns the new value and the
+// time to stamp it with. The time is at, unless the counter was updated at or
+// after it, e.g. by an event delivered late, and is then a millisecond after
+// the last update. The value and time are updated together, so a higher value
+// never has an earlier time. The table has a string partition key "id".
+func IncrementCounter(table string, id string, delta float64, at time.Time) (float64, time.Time, error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(id)},
+		},
+		UpdateExpression:         aws.String("ADD #value :delta SET #updated = :at"),
+		ConditionExpression:      aws.String("attribute_not_exists(#updated) OR #updated < :at"),
+		ExpressionAttributeNames: map[string]*string{"#value": aws.String("value"), "#updated": aws.String("updated")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":delta": {N: aws.String(strconv.FormatFloat(delta, 'f', -1, 64))},
+			":at":    {N: aws.String(strconv.FormatInt(at.UnixMilli(), 10))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	}
+	result, err := getDynamoDBService().UpdateItem(input)
+	var conditionFailed *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		// Updated at or after the event, move the time just past the last update
+		input.UpdateExpression = aws.String("ADD #value :delta, #updated :step")
+		input.ConditionExpression = nil
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":delta": input.ExpressionAttributeValues[":delta"],
+			":step":  {N: aws.String("1")},
+		}
+		result, err = getDynamoDBService().UpdateItem(input)
+	}
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to increment counter %s: %w", id, err)
+	}
+	value, err := strconv.ParseFloat(aws.StringValue(result.Attributes["value"].N), 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid value of counter %s: %w", id, err)
+	}
+	updated, err := strconv.ParseInt(aws.StringValue(result.Attributes["updated"].N), 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid update time of counter %s: %w", id, err)
+	}
+	return value, time.UnixMilli(updated), nil
+}
+
+// AcquireLease stores a lease under id in table that expires at until, unless
+// an unexpired lease is already stored. It reports whether the lease was
+// acquired, e.g. so that only one of several Lambda containers acts on it.
+func AcquireLease(table string, id string, until time.Time) (bool, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	_, err := getDynamoDBService().PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":      {S: aws.String(id)},
+			"expires": {N: aws.String(strconv.FormatInt(until.Unix(), 10))},
+		},
+		ConditionExpression:       aws.String("attribute_not_exists(id) OR expires < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":now": {N: aws.String(now)}},
+	})
+	if err != nil {
+		var conditionFailed *dynamodb.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to acquire lease %s: %w", id, err)
+	}
+	return true, nil
+}
+
+// StateIndex is the global secondary index of the state table that holds the
+// states, with the string partition key "kind" and sort key "id". Only state
+// items have a kind, so states are listed without reading counters and leases.
+const StateIndex = "kind-id"
+
+// stateKind is the kind of state items in StateIndex.
+const stateKind = "state"
+
+// State is a value stored in the state table with the label values of its
+// series.
+type State struct {
+	ID          string
+	LabelValues []string
+	Value       float64
+}
+
+// PutState stores a state in table, replacing its previous value.
+func PutState(table string, state State) error {
+	labelValues := make([]*dynamodb.AttributeValue, 0, len(state.LabelValues))
+	for _, value := range state.LabelValues {
+		labelValues = append(labelValues, &dynamodb.AttributeValue{S: aws.String(value)})
+	}
+	_, err := getDynamoDBService().PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":     {S: aws.String(state.ID)},
+			"kind":   {S: aws.String(stateKind)},
+			"labels": {L: labelValues},
+			"value":  {N: aws.String(strconv.FormatFloat(state.Value, 'f', -1, 64))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store state %s: %w", state.ID, err)
+	}
+	return nil
+}
+
+// ListStates returns the states stored in table whose id starts with prefix.
+// They are queried from StateIndex, which is eventually consistent, so a
+// state stored moments ago may be missing.
+func ListStates(table string, prefix string) ([]State, error) {
+	var states []State
+	var parseErr error
+	err := getDynamoDBService().QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		IndexName:              aws.String(StateIndex),
+		KeyConditionExpression: aws.String("kind = :kind AND begins_with(id, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":kind":   {S: aws.String(stateKind)},
+			":prefix": {S: aws.String(prefix)},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			state := State{ID: aws.StringValue(item["id"].S)}
+			if item["value"] == nil {
+				continue
+			}
+			value, err := strconv.ParseFloat(aws.StringValue(item["value"].N), 64)
+			if err != nil {
+				parseErr = fmt.Errorf("invalid value of state %s: %w", state.ID, err)
+				continue
+			}
+			state.Value = value
+			if item["labels"] != nil {
+				for _, label := range item["labels"].L {
+					state.LabelValues = append(state.LabelValues, aws.StringValue(label.S))
+				}
+			}
+			states = append(states, state)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list states %s: %w", prefix, err)
+	}
+	return states, parseErr
+}
+
+// ReleaseLease deletes the lease stored under id in table, if it is still the
+// one that expires at until, e.g. when the action it guarded failed and may
+// be retried.
+func ReleaseLease(table string, id string, until time.Time) error {
+	_, err := getDynamoDBService().DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(id)},
+		},
+		ConditionExpression:       aws.String("expires = :expires"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":expires": {N: aws.String(strconv.FormatInt(until.Unix(), 10))}},
+	})
+	if err != nil {
+		var conditionFailed *dynamodb.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil
+		}
+		return fmt.Errorf("failed to release lease %s: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package aws
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// GetServiceTasks returns the ARN of the running task and the sorted ARNs of
+// the running tasks of its ECS service.
+func GetServiceTasks() (string, []string, error) {
+	task, err := getTaskMetadata()
+	if err != nil {
+		return "", nil, err
+	}
+
+	sess := session.Must(session.NewSession())
+	svc := ecs.New(sess, aws.NewConfig().WithRegion(GetRegion()))
+
+	described, err := svc.DescribeTasks(&ecs.DescribeTasksInput{
+		Cluster: aws.String(task.Cluster),
+		Tasks:   aws.StringSlice([]string{task.TaskARN}),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to describe task: %w", err)
+	}
+	if len(described.Tasks) == 0 {
+		return "", nil, fmt.Errorf("task %s not found", task.TaskARN)
+	}
+	serviceName, found := strings.CutPrefix(aws.StringValue(described.Tasks[0].Group), "service:")
+	if !found {
+		return "", nil, fmt.Errorf("task %s is not part of a service", task.TaskARN)
+	}
+
+	var taskARNs []string
+	input := &ecs.ListTasksInput{
+		Cluster:       aws.String(task.Cluster),
+		ServiceName:   aws.String(serviceName),
+		DesiredStatus: aws.String(ecs.DesiredStatusRunning),
+	}
+	err = svc.ListTasksPages(input, func(page *ecs.ListTasksOutput, lastPage bool) bool {
+		taskARNs = append(taskARNs, aws.StringValueSlice(page.TaskArns)...)
+		return true
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list service tasks: %w", err)
+	}
+
+	slices.Sort(taskARNs)
+	if !slices.Contains(taskARNs, task.TaskARN) {
+		return "", nil, fmt.Errorf("task %s is not running in service %s", task.TaskARN, serviceName)
+	}
+	return task.TaskARN, taskARNs, nil
+}
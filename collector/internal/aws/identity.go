@@ -0,0 +1,96 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+var (
+	region     string
+	regionOnce sync.Once
+
+	accountID     string
+	accountIDOnce sync.Once
+
+	metadataClient = &http.Client{Timeout: 2 * time.Second}
+)
+
+// GetRegion returns the region the collector runs in. It is taken from
+// AWS_REGION or AWS_DEFAULT_REGION and otherwise looked up from the ECS task
+// metadata or the EC2 instance metadata endpoint.
+func GetRegion() string {
+	regionOnce.Do(func() {
+		for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+			if region = os.Getenv(env); region != "" {
+				return
+			}
+		}
+		if task, err := getTaskMetadata(); err == nil {
+			if parts := strings.Split(task.TaskARN, ":"); len(parts) > 3 {
+				region = parts[3]
+				return
+			}
+		}
+		sess := session.Must(session.NewSession(aws.NewConfig().WithHTTPClient(metadataClient)))
+		if value, err := ec2metadata.New(sess).Region(); err == nil {
+			region = value
+		} else {
+			fmt.Println("Unable to determine region:", err)
+		}
+	})
+	return region
+}
+
+// GetAccountID returns the account the collector runs in. It is taken from
+// AWS_ACCOUNT_ID and otherwise looked up with STS GetCallerIdentity.
+func GetAccountID() string {
+	accountIDOnce.Do(func() {
+		if accountID = os.Getenv("AWS_ACCOUNT_ID"); accountID != "" {
+			return
+		}
+		sess := session.Must(session.NewSession())
+		svc := sts.New(sess, aws.NewConfig().WithRegion(GetRegion()))
+		result, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+		if err != nil {
+			fmt.Println("Unable to determine account ID:", err)
+			return
+		}
+		accountID = aws.StringValue(result.Account)
+	})
+	return accountID
+}
+
+// taskMetadata is the part of the ECS task metadata used by the collector.
+type taskMetadata struct {
+	Cluster string `json:"Cluster"`
+	TaskARN string `json:"TaskARN"`
+}
+
+// getTaskMetadata reads the task metadata from the ECS task metadata endpoint.
+func getTaskMetadata() (*taskMetadata, error) {
+	metadataURI := os.Getenv("ECS_CONTAINER_METADATA_URI_V4")
+	if metadataURI == "" {
+		return nil, fmt.Errorf("ECS_CONTAINER_METADATA_URI_V4 is not set")
+	}
+	resp, err := metadataClient.Get(metadataURI + "/task")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	task := &taskMetadata{}
+	if err := json.NewDecoder(resp.Body).Decode(task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
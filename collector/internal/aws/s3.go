@@ -0,0 +1,38 @@
+package aws
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// GetS3Object returns the content of the object at an s3://bucket/key URI.
+// The object is read from the bucket's own region.
+func GetS3Object(uri string) ([]byte, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "s3" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid S3 URI %q", uri)
+	}
+	bucket, key := parsed.Host, strings.TrimPrefix(parsed.Path, "/")
+
+	sess := session.Must(session.NewSession())
+	region, err := s3manager.GetBucketRegion(aws.BackgroundContext(), sess, bucket, GetRegion())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find region of bucket %s: %w", bucket, err)
+	}
+	result, err := s3.New(sess, aws.NewConfig().WithRegion(region)).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", uri, err)
+	}
+	defer result.Body.Close()
+	return io.ReadAll(result.Body)
+}
@@ -2,11 +2,15 @@ package aws
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"sync"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-secretsmanager-caching-go/secretcache"
-	"os"
 )
 
 var (
@@ -15,40 +19,194 @@ var (
 		VersionStage: secretcache.DefaultVersionStage,
 		CacheItemTTL: secretcache.DefaultCacheItemTTL,
 	}
-	secretCache, _ = secretcache.New(func(cache *secretcache.Cache) {
+
+	sources     []*secretSource
+	sourcesOnce sync.Once
+)
+
+// secretSource is an account and region secrets are discovered in, with the
+// client and cache used to read them.
+type secretSource struct {
+	accountID string
+	region    string
+	svc       *secretsmanager.SecretsManager
+	cache     *secretcache.Cache
+}
+
+// getSources returns the sources secrets are discovered in. Secrets are
+// listed in every region of DISCOVERY_REGIONS (comma separated, default: the
+// collector's region), both in the collector's own account and in the account
+// of every role in DISCOVERY_ROLE_ARNS, which are assumed with the optional
+// DISCOVERY_EXTERNAL_ID.
+func getSources() []*secretSource {
+	sourcesOnce.Do(func() {
+		regions := splitList(os.Getenv("DISCOVERY_REGIONS"))
+		if len(regions) == 0 {
+			regions = []string{GetRegion()}
+		}
+		sess := session.Must(session.NewSession())
+		for _, region := range regions {
+			sources = append(sources, newSecretSource(GetAccountID(), region, aws.NewConfig().WithRegion(region)))
+			for _, roleARN := range splitList(os.Getenv("DISCOVERY_ROLE_ARNS")) {
+				parsed, err := arn.Parse(roleARN)
+				if err != nil {
+					fmt.Println("Invalid discovery role ARN:", roleARN, err)
+					continue
+				}
+				credentials := AssumeRoleCredentials(sess, roleARN, os.Getenv("DISCOVERY_EXTERNAL_ID"))
+				sources = append(sources, newSecretSource(parsed.AccountID, region,
+					aws.NewConfig().WithRegion(region).WithCredentials(credentials)))
+			}
+		}
+	})
+	return sources
+}
+
+func newSecretSource(accountID string, region string, cfg *aws.Config) *secretSource {
+	svc := secretsmanager.New(session.Must(session.NewSession()), cfg)
+	cache, _ := secretcache.New(func(cache *secretcache.Cache) {
 		cache.CacheConfig = config
+		cache.Client = svc
 	})
-)
+	return &secretSource{
+		accountID: accountID,
+		region:    region,
+		svc:       svc,
+		cache:     cache,
+	}
+}
+
+// getSource returns the source a secret ARN belongs to, falling back to the
+// first source for plain secret names.
+func getSource(secret string) *secretSource {
+	sources := getSources()
+	if parsed, err := arn.Parse(secret); err == nil {
+		for _, source := range sources {
+			if source.accountID == parsed.AccountID && source.region == parsed.Region {
+				return source
+			}
+		}
+	}
+	return sources[0]
+}
+
+// SourceError is the error of listing the secrets of one account and region.
+type SourceError struct {
+	AccountID string
+	Region    string
+	Err       error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("failed to list secrets in %s %s: %v", e.AccountID, e.Region, e.Err)
+}
 
-func getService() *secretsmanager.SecretsManager {
-	region := os.Getenv("AWS_REGION")
-	sess := session.Must(session.NewSession())
-	svc := secretsmanager.New(sess, aws.NewConfig().WithRegion(region))
-	return svc
-}
-
-func ListSecrets() *secretsmanager.ListSecretsOutput {
-	svc := getService()
-	input := &secretsmanager.ListSecretsInput{
-		MaxResults: aws.Int64(100),
-		Filters: []*secretsmanager.Filter{
-			{
-				Key:    aws.String("tag-key"),
-				Values: aws.StringSlice([]string{"database-collector:enabled"}),
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// ListSecretsError is returned by ListSecrets when the secrets of some
+// sources could not be listed. Their secrets are missing from the listing.
+type ListSecretsError struct {
+	Sources []*SourceError
+}
+
+func (e *ListSecretsError) Error() string {
+	messages := make([]string, 0, len(e.Sources))
+	for _, source := range e.Sources {
+		messages = append(messages, source.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ListSecretsError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Sources))
+	for _, source := range e.Sources {
+		errs = append(errs, source)
+	}
+	return errs
+}
+
+// Failed reports whether the secrets of the account and region could not be
+// listed, so a secret of theirs missing from the listing may still exist. It
+// is false for a nil error.
+func (e *ListSecretsError) Failed(accountID string, region string) bool {
+	if e == nil {
+		return false
+	}
+	for _, source := range e.Sources {
+		if source.AccountID == accountID && source.Region == region {
+			return true
+		}
+	}
+	return false
+}
+
+// FailedSecret reports whether the source of a secret ARN could not be listed.
+func (e *ListSecretsError) FailedSecret(secretARN string) bool {
+	parsed, err := arn.Parse(secretARN)
+	return err == nil && e.Failed(parsed.AccountID, parsed.Region)
+}
+
+// ListSecrets lists the secrets tagged for collection in every source.
+// Secrets should be referred to by ARN, their names may repeat across
+// accounts and regions. A source that cannot be listed does not stop the
+// others; the secrets of the rest are returned with a *ListSecretsError.
+func ListSecrets() (*secretsmanager.ListSecretsOutput, error) {
+	result := &secretsmanager.ListSecretsOutput{}
+	var listErr *ListSecretsError
+	for _, source := range getSources() {
+		input := &secretsmanager.ListSecretsInput{
+			MaxResults: aws.Int64(100),
+			Filters: []*secretsmanager.Filter{
+				{
+					Key:    aws.String("tag-key"),
+					Values: aws.StringSlice([]string{"database-collector:enabled"}),
+				},
 			},
-		},
+		}
+		// A partially listed source counts as failed, its pages are dropped
+		var secrets []*secretsmanager.SecretListEntry
+		err := source.svc.ListSecretsPages(input, func(page *secretsmanager.ListSecretsOutput, lastPage bool) bool {
+			secrets = append(secrets, page.SecretList...)
+			return true
+		})
+		if err != nil {
+			if listErr == nil {
+				listErr = &ListSecretsError{}
+			}
+			listErr.Sources = append(listErr.Sources, &SourceError{AccountID: source.accountID, Region: source.region, Err: err})
+			continue
+		}
+		result.SecretList = append(result.SecretList, secrets...)
 	}
-	result, err := svc.ListSecrets(input)
-	if err != nil {
-		fmt.Println(err)
+	if listErr != nil {
+		return result, listErr
+	}
+	return result, nil
+}
+
+// GetSecretValue returns the cached value of a secret, or an error if it
+// cannot be read.
+func GetSecretValue(secret string) (string, error) {
+	return getSource(secret).cache.GetSecretString(secret)
+}
+
+// GetSecretTags returns the tags of a listed secret as a plain key/value map.
+func GetSecretTags(secret *secretsmanager.SecretListEntry) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range secret.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
 	}
-	return result
+	return tags
 }
 
-func GetSecretsValue(secret string) string {
-	var result, err = secretCache.GetSecretString(secret)
-	if err != nil {
-		panic(err)
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
-	return result
+	return items
 }
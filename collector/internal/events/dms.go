@@ -0,0 +1,138 @@
+package events
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DmsEventMessage is the detail of a DMS event delivered by EventBridge.
+type DmsEventMessage struct {
+	Type          string `json:"type"`
+	Category      string `json:"category"`
+	EventType     string `json:"eventType"`
+	EventID       string `json:"eventId"`
+	ResourceLink  string `json:"resourceLink"`
+	DetailMessage string `json:"detailMessage"`
+}
+
+var dmsEventIDPattern = regexp.MustCompile(`DMS-EVENT-\d+`)
+
+// dmsSourceType describes one kind of DMS event source and its metric.
+type dmsSourceType struct {
+	// sourceType is the type of the event detail
+	sourceType string
+	// arnResource is the resource type in the source ARN
+	arnResource string
+	counter     *Counter
+}
+
+func newDmsEventCounter(name string, source string) *Counter {
+	return NewCounter(name, "Number of DMS events for "+source+".", rdsEventLabels)
+}
+
+var dmsSourceTypes = []*dmsSourceType{
+	{"REPLICATION_TASK", "task", newDmsEventCounter("dms_replication_task_events_total", "replication tasks")},
+	{"REPLICATION_INSTANCE", "rep", newDmsEventCounter("dms_replication_instance_events_total", "replication instances")},
+}
+
+// DmsCollectors returns the per source type DMS event counters for
+// registration.
+func DmsCollectors() []prometheus.Collector {
+	collectors := make([]prometheus.Collector, 0, len(dmsSourceTypes))
+	for _, sourceType := range dmsSourceTypes {
+		collectors = append(collectors, sourceType.counter)
+	}
+	return collectors
+}
+
+// dmsEvent adapts a DMS event to Event.
+type dmsEvent struct {
+	message   DmsEventMessage
+	resources []string
+	delivered time.Time
+}
+
+func (e dmsEvent) Source() string { return "aws.dms" }
+
+// EventID returns the DMS event ID, e.g. DMS-EVENT-0069.
+func (e dmsEvent) EventID() string {
+	for _, value := range []string{e.message.EventID, e.message.DetailMessage} {
+		if id := dmsEventIDPattern.FindString(value); id != "" {
+			return id
+		}
+	}
+	return noEventID
+}
+
+func (e dmsEvent) Category() string {
+	if category := strings.TrimSpace(e.message.Category); category != "" {
+		return strings.ToLower(category)
+	}
+	return noEventID
+}
+
+// sourceType finds the source type of the event from its type or its source
+// ARN.
+func (e dmsEvent) sourceType() *dmsSourceType {
+	resource, _ := arnResource(e.resources...)
+	for _, sourceType := range dmsSourceTypes {
+		if strings.EqualFold(e.message.Type, sourceType.sourceType) || resource == sourceType.arnResource {
+			return sourceType
+		}
+	}
+	return nil
+}
+
+func (e dmsEvent) SourceType() string {
+	if sourceType := e.sourceType(); sourceType != nil {
+		return sourceType.sourceType
+	}
+	if e.message.Type != "" {
+		return strings.ToUpper(e.message.Type)
+	}
+	return unknownSourceType
+}
+
+// Identifier returns the name of the task or instance from the console link
+// of the event, since the ARNs of DMS resources hold a generated ID, or
+// otherwise the ID from the ARN.
+func (e dmsEvent) Identifier() string {
+	if link := strings.TrimRight(e.message.ResourceLink, "/"); link != "" {
+		if i := strings.LastIndexAny(link, "/="); i >= 0 && i < len(link)-1 {
+			return link[i+1:]
+		}
+	}
+	_, name := arnResource(e.resources...)
+	return name
+}
+
+func (e dmsEvent) SourceArn() string {
+	if len(e.resources) > 0 {
+		return e.resources[0]
+	}
+	return ""
+}
+
+func (e dmsEvent) Message() string { return e.message.DetailMessage }
+func (e dmsEvent) Time() time.Time { return eventTime(e.delivered) }
+
+// Record counts the event in the counter of its source type. Events of other
+// source types are only counted in rds_service_events.
+func (e dmsEvent) Record(at time.Time) error {
+	if sourceType := e.sourceType(); sourceType != nil {
+		return sourceType.counter.Inc(at, e.Identifier(), e.EventID(), e.Category())
+	}
+	return nil
+}
+
+func (e dmsEvent) CounterName() string {
+	if sourceType := e.sourceType(); sourceType != nil {
+		return sourceType.counter.name
+	}
+	return ""
+}
+
+func (e dmsEvent) RecordState(time.Time) error { return nil }
@@ -0,0 +1,173 @@
+package events
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// elastiCacheSourceType describes one kind of ElastiCache event source and
+// its metric.
+type elastiCacheSourceType struct {
+	sourceType string
+	// idKey is the key of the resource name in the event detail
+	idKey string
+	// arnResource is the resource type in the source ARN
+	arnResource string
+	counter     *Counter
+}
+
+func newElastiCacheEventCounter(name string, source string) *Counter {
+	return NewCounter(name, "Number of ElastiCache events for "+source+".", rdsEventLabels)
+}
+
+var elastiCacheSourceTypes = []*elastiCacheSourceType{
+	{"REPLICATION_GROUP", "replication-group-id", "replicationgroup", newElastiCacheEventCounter("elasticache_replication_group_events_total", "replication groups")},
+	{"CACHE_CLUSTER", "cache-cluster-id", "cluster", newElastiCacheEventCounter("elasticache_cluster_events_total", "cache clusters")},
+	{"SERVERLESS_CACHE", "serverless-cache-name", "serverlesscache", newElastiCacheEventCounter("elasticache_serverless_cache_events_total", "serverless caches")},
+	{"SNAPSHOT", "snapshot-name", "snapshot", newElastiCacheEventCounter("elasticache_snapshot_events_total", "snapshots")},
+}
+
+// ElastiCacheCollectors returns the per source type ElastiCache event
+// counters for registration.
+func ElastiCacheCollectors() []prometheus.Collector {
+	collectors := make([]prometheus.Collector, 0, len(elastiCacheSourceTypes))
+	for _, sourceType := range elastiCacheSourceTypes {
+		collectors = append(collectors, sourceType.counter)
+	}
+	return collectors
+}
+
+// elastiCacheEvent adapts an ElastiCache event to Event. The detail of
+// ElastiCache events differs between event types, so it is kept as a map.
+type elastiCacheEvent struct {
+	message    map[string]interface{}
+	detailType string
+	resources  []string
+	delivered  time.Time
+}
+
+// value returns the first non-empty string value of the given keys.
+func (e elastiCacheEvent) value(keys ...string) string {
+	for _, key := range keys {
+		if value, ok := e.message[key]; ok && value != nil {
+			if s := strings.TrimSpace(fmt.Sprint(value)); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+func (e elastiCacheEvent) Source() string { return "aws.elasticache" }
+
+// EventID returns the name of the event, e.g. ElastiCache:SnapshotComplete,
+// or its detail-type, e.g. ElastiCache Snapshot Complete, when the detail has
+// no event name.
+func (e elastiCacheEvent) EventID() string {
+	if id := e.value("event", "Event", "event-id", "EventID"); id != "" {
+		return id
+	}
+	if e.detailType != "" {
+		return e.detailType
+	}
+	return noEventID
+}
+
+func (e elastiCacheEvent) Category() string {
+	var categories []string
+	switch value := e.message["EventCategories"].(type) {
+	case []interface{}:
+		for _, category := range value {
+			if s := strings.TrimSpace(fmt.Sprint(category)); s != "" {
+				categories = append(categories, strings.ToLower(s))
+			}
+		}
+	}
+	if category := e.value("category", "Category"); category != "" {
+		categories = append(categories, strings.ToLower(category))
+	}
+	if len(categories) == 0 {
+		return noEventID
+	}
+	sort.Strings(categories)
+	return strings.Join(categories, ",")
+}
+
+// sourceType finds the source type of the event from the resource name in
+// its detail or from its source ARN.
+func (e elastiCacheEvent) sourceType() *elastiCacheSourceType {
+	for _, sourceType := range elastiCacheSourceTypes {
+		if e.value(sourceType.idKey) != "" {
+			return sourceType
+		}
+	}
+	resource, _ := arnResource(e.resources...)
+	for _, sourceType := range elastiCacheSourceTypes {
+		if resource == sourceType.arnResource {
+			return sourceType
+		}
+	}
+	return nil
+}
+
+func (e elastiCacheEvent) SourceType() string {
+	if sourceType := e.sourceType(); sourceType != nil {
+		return sourceType.sourceType
+	}
+	return unknownSourceType
+}
+
+// Identifier returns the name of the event's source from the detail or
+// otherwise from the last part of its ARN.
+func (e elastiCacheEvent) Identifier() string {
+	if sourceType := e.sourceType(); sourceType != nil {
+		if id := e.value(sourceType.idKey); id != "" {
+			return id
+		}
+	}
+	if id := e.value("source-id", "SourceIdentifier"); id != "" {
+		return id
+	}
+	_, name := arnResource(e.resources...)
+	return name
+}
+
+func (e elastiCacheEvent) SourceArn() string {
+	if len(e.resources) > 0 {
+		return e.resources[0]
+	}
+	return e.value("SourceArn")
+}
+
+func (e elastiCacheEvent) Message() string {
+	return e.value("message", "Message")
+}
+
+func (e elastiCacheEvent) Time() time.Time {
+	if at, err := time.Parse(time.RFC3339Nano, e.value("date", "Date")); err == nil {
+		return at
+	}
+	return eventTime(e.delivered)
+}
+
+// Record counts the event in the counter of its source type. Events of other
+// source types are only counted in rds_service_events.
+func (e elastiCacheEvent) Record(at time.Time) error {
+	if sourceType := e.sourceType(); sourceType != nil {
+		return sourceType.counter.Inc(at, e.Identifier(), e.EventID(), e.Category())
+	}
+	return nil
+}
+
+func (e elastiCacheEvent) CounterName() string {
+	if sourceType := e.sourceType(); sourceType != nil {
+		return sourceType.counter.name
+	}
+	return ""
+}
+
+func (e elastiCacheEvent) RecordState(time.Time) error { return nil }
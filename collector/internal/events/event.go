@@ -0,0 +1,152 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Event is an event of a supported AWS service, delivered by EventBridge.
+type Event interface {
+	// Source returns the EventBridge source of the event, e.g. aws.rds
+	Source() string
+	// EventID returns the service's ID of the event, e.g. RDS-EVENT-0006
+	EventID() string
+	// Category returns the event categories, sorted and comma separated
+	Category() string
+	// SourceType returns the kind of resource the event is about, e.g.
+	// DB_INSTANCE or REPLICATION_TASK
+	SourceType() string
+	// Identifier returns the name of the resource the event is about
+	Identifier() string
+	// SourceArn returns the ARN of the resource the event is about
+	SourceArn() string
+	// Message returns the free-text message of the event
+	Message() string
+	// Time returns when the event happened
+	Time() time.Time
+	// Record counts the event in the counter of its source type
+	Record(at time.Time) error
+	// CounterName returns the name of the counter of the event's source
+	// type, or "" when its source type is unknown
+	CounterName() string
+	// RecordState updates the state gauges changed by the event
+	RecordState(at time.Time) error
+}
+
+// detailTypePrefixes holds the prefix of the detail-types of the service
+// events of each supported source. Other events from the same sources, e.g.
+// AWS API Call via CloudTrail, are not service events.
+var detailTypePrefixes = map[string]string{
+	"aws.rds":         "RDS ",
+	"aws.dms":         "DMS ",
+	"aws.elasticache": "ElastiCache ",
+}
+
+// Parse returns the event in the detail of an EventBridge event from the
+// given source, e.g. aws.rds. Events whose detail-type is not one of the
+// source's service events are rejected.
+func Parse(source string, detailType string, detail json.RawMessage, resources []string, delivered time.Time) (Event, error) {
+	prefix, ok := detailTypePrefixes[source]
+	if !ok {
+		return nil, fmt.Errorf("unsupported event source %q", source)
+	}
+	if !strings.HasPrefix(detailType, prefix) {
+		return nil, fmt.Errorf("unsupported %s event detail type %q", source, detailType)
+	}
+
+	switch source {
+	case "aws.rds":
+		message := RdsEventMessage{}
+		if err := json.Unmarshal(detail, &message); err != nil {
+			return nil, err
+		}
+		return rdsEvent{message: message, detailType: detailType, delivered: delivered}, nil
+	case "aws.dms":
+		message := DmsEventMessage{}
+		if err := json.Unmarshal(detail, &message); err != nil {
+			return nil, err
+		}
+		return dmsEvent{message: message, resources: resources, delivered: delivered}, nil
+	case "aws.elasticache":
+		message := map[string]interface{}{}
+		if err := json.Unmarshal(detail, &message); err != nil {
+			return nil, err
+		}
+		return elastiCacheEvent{message: message, detailType: detailType, resources: resources, delivered: delivered}, nil
+	}
+	return nil, fmt.Errorf("unsupported event source %q", source)
+}
+
+// Collectors returns the per source type event counters of all supported
+// services for registration.
+func Collectors() []prometheus.Collector {
+	var collectors []prometheus.Collector
+	collectors = append(collectors, RdsCollectors()...)
+	collectors = append(collectors, DmsCollectors()...)
+	collectors = append(collectors, ElastiCacheCollectors()...)
+	return collectors
+}
+
+// eventTime returns the delivery time of the EventBridge event, or the
+// current time, for services whose events carry no time of their own.
+func eventTime(delivered time.Time) time.Time {
+	if !delivered.IsZero() {
+		return delivered
+	}
+	return time.Now()
+}
+
+// arnResource returns the resource type and name of the first parsable ARN,
+// e.g. task and ABCDEF for arn:aws:dms:us-east-1:123456789012:task:ABCDEF.
+func arnResource(arns ...string) (string, string) {
+	for _, value := range arns {
+		if parsed, err := arn.Parse(value); err == nil {
+			// split once, names may contain the separator, e.g. rds:db-2024
+			index := strings.IndexAny(parsed.Resource, ":/")
+			if index < 0 {
+				if parsed.Resource == "" {
+					continue
+				}
+				return parsed.Resource, parsed.Resource
+			}
+			return parsed.Resource[:index], parsed.Resource[index+1:]
+		}
+	}
+	return "", ""
+}
+
+// rdsEvent adapts an RDS event to Event.
+type rdsEvent struct {
+	message    RdsEventMessage
+	detailType string
+	delivered  time.Time
+}
+
+func (e rdsEvent) Source() string     { return "aws.rds" }
+func (e rdsEvent) EventID() string    { return e.message.RdsEventID() }
+func (e rdsEvent) Category() string   { return e.message.Category() }
+func (e rdsEvent) SourceType() string { return e.message.NormalizedSourceType(e.detailType) }
+func (e rdsEvent) Identifier() string { return e.message.Identifier() }
+func (e rdsEvent) SourceArn() string  { return e.message.SourceArn }
+func (e rdsEvent) Message() string    { return e.message.Message }
+func (e rdsEvent) Time() time.Time    { return e.message.Time(e.delivered) }
+
+func (e rdsEvent) Record(at time.Time) error {
+	return e.message.Record(e.detailType, at)
+}
+
+func (e rdsEvent) CounterName() string {
+	if sourceType := e.message.sourceType(e.detailType); sourceType != nil {
+		return sourceType.counter.name
+	}
+	return ""
+}
+
+func (e rdsEvent) RecordState(at time.Time) error {
+	return e.message.RecordState(at)
+}
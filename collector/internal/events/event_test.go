@@ -0,0 +1,187 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	delivered := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	tests := []struct {
+		name           string
+		source         string
+		detailType     string
+		detail         string
+		resources      []string
+		wantEventID    string
+		wantCategory   string
+		wantSourceType string
+		wantCounter    string
+		wantIdentifier string
+		wantMessage    string
+		wantTime       time.Time
+	}{
+		{
+			name:       "rds instance",
+			source:     "aws.rds",
+			detailType: "RDS DB Instance Event",
+			detail: `{"EventCategories":["availability"],"SourceType":"DB_INSTANCE","SourceArn":"arn:aws:rds:us-east-1:123456789012:db:orders",
+				"Date":"2024-01-01T12:00:00.123Z","SourceIdentifier":"orders","Message":"DB instance restarted","EventID":"RDS-EVENT-0006"}`,
+			wantEventID:    "RDS-EVENT-0006",
+			wantCategory:   "availability",
+			wantSourceType: "DB_INSTANCE",
+			wantCounter:    "rds_instance_events_total",
+			wantIdentifier: "orders",
+			wantMessage:    "DB instance restarted",
+			wantTime:       time.Date(2024, 1, 1, 12, 0, 0, 123e6, time.UTC),
+		},
+		{
+			name:       "rds event id in message and source from arn",
+			source:     "aws.rds",
+			detailType: "RDS DB Snapshot Event",
+			detail: `{"EventCategories":["Backup","creation"],"SourceArn":"arn:aws:rds:us-east-1:123456789012:snapshot:rds:orders-2024-01-01",
+				"Message":"Automated snapshot created (RDS-EVENT-0091)"}`,
+			wantEventID:    "RDS-EVENT-0091",
+			wantCategory:   "backup,creation",
+			wantSourceType: "SNAPSHOT",
+			wantCounter:    "rds_snapshot_events_total",
+			wantIdentifier: "rds:orders-2024-01-01",
+			wantMessage:    "Automated snapshot created (RDS-EVENT-0091)",
+			wantTime:       delivered,
+		},
+		{
+			name:           "rds unknown source type",
+			source:         "aws.rds",
+			detailType:     "RDS Something New",
+			detail:         `{"SourceIdentifier":"orders"}`,
+			wantEventID:    noEventID,
+			wantCategory:   noEventID,
+			wantSourceType: unknownSourceType,
+			wantIdentifier: "orders",
+			wantTime:       delivered,
+		},
+		{
+			name:       "dms replication task",
+			source:     "aws.dms",
+			detailType: "DMS Replication Task State Change",
+			detail: `{"type":"REPLICATION_TASK","category":"StateChange","eventType":"REPLICATION_TASK_STOPPED","eventId":"DMS-EVENT-0079",
+				"resourceLink":"https://console.aws.amazon.com/dms/v2/home?region=us-east-1#taskDetails/orders-cdc","detailMessage":"Stop Reason FULL_LOAD_ONLY_FINISHED"}`,
+			resources:      []string{"arn:aws:dms:us-east-1:123456789012:task:ABCDEF"},
+			wantEventID:    "DMS-EVENT-0079",
+			wantCategory:   "statechange",
+			wantSourceType: "REPLICATION_TASK",
+			wantCounter:    "dms_replication_task_events_total",
+			wantIdentifier: "orders-cdc",
+			wantMessage:    "Stop Reason FULL_LOAD_ONLY_FINISHED",
+			wantTime:       delivered,
+		},
+		{
+			name:           "dms replication instance from arn",
+			source:         "aws.dms",
+			detailType:     "DMS Replication Instance State Change",
+			detail:         `{"category":"Failure","detailMessage":"Replication instance failed (DMS-EVENT-0031)"}`,
+			resources:      []string{"arn:aws:dms:us-east-1:123456789012:rep:GHIJKL"},
+			wantEventID:    "DMS-EVENT-0031",
+			wantCategory:   "failure",
+			wantSourceType: "REPLICATION_INSTANCE",
+			wantCounter:    "dms_replication_instance_events_total",
+			wantIdentifier: "GHIJKL",
+			wantMessage:    "Replication instance failed (DMS-EVENT-0031)",
+			wantTime:       delivered,
+		},
+		{
+			name:           "elasticache snapshot",
+			source:         "aws.elasticache",
+			detailType:     "ElastiCache Snapshot Complete",
+			detail:         `{"event":"ElastiCache:SnapshotComplete","snapshot-name":"orders-backup","date":"2024-01-01T11:59:00Z"}`,
+			resources:      []string{"arn:aws:elasticache:us-east-1:123456789012:snapshot:orders-backup"},
+			wantEventID:    "ElastiCache:SnapshotComplete",
+			wantCategory:   noEventID,
+			wantSourceType: "SNAPSHOT",
+			wantCounter:    "elasticache_snapshot_events_total",
+			wantIdentifier: "orders-backup",
+			wantTime:       time.Date(2024, 1, 1, 11, 59, 0, 0, time.UTC),
+		},
+		{
+			name:           "elasticache replication group from arn without message",
+			source:         "aws.elasticache",
+			detailType:     "ElastiCache Replication Group Failover",
+			detail:         `{}`,
+			resources:      []string{"arn:aws:elasticache:us-east-1:123456789012:replicationgroup:orders"},
+			wantEventID:    "ElastiCache Replication Group Failover",
+			wantCategory:   noEventID,
+			wantSourceType: "REPLICATION_GROUP",
+			wantCounter:    "elasticache_replication_group_events_total",
+			wantIdentifier: "orders",
+			wantTime:       delivered,
+		},
+		{
+			name:           "elasticache serverless cache",
+			source:         "aws.elasticache",
+			detailType:     "ElastiCache Serverless Cache Updated",
+			detail:         `{"event":"ElastiCache:ServerlessCacheUpdated","serverless-cache-name":"sessions","EventCategories":["configuration change"]}`,
+			wantEventID:    "ElastiCache:ServerlessCacheUpdated",
+			wantCategory:   "configuration change",
+			wantSourceType: "SERVERLESS_CACHE",
+			wantCounter:    "elasticache_serverless_cache_events_total",
+			wantIdentifier: "sessions",
+			wantTime:       delivered,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := Parse(tt.source, tt.detailType, json.RawMessage(tt.detail), tt.resources, delivered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.Source() != tt.source {
+				t.Errorf("Source() = %q, want %q", event.Source(), tt.source)
+			}
+			if got := event.EventID(); got != tt.wantEventID {
+				t.Errorf("EventID() = %q, want %q", got, tt.wantEventID)
+			}
+			if got := event.Category(); got != tt.wantCategory {
+				t.Errorf("Category() = %q, want %q", got, tt.wantCategory)
+			}
+			if got := event.SourceType(); got != tt.wantSourceType {
+				t.Errorf("SourceType() = %q, want %q", got, tt.wantSourceType)
+			}
+			if got := event.CounterName(); got != tt.wantCounter {
+				t.Errorf("CounterName() = %q, want %q", got, tt.wantCounter)
+			}
+			if got := event.Identifier(); got != tt.wantIdentifier {
+				t.Errorf("Identifier() = %q, want %q", got, tt.wantIdentifier)
+			}
+			if got := event.Message(); got != tt.wantMessage {
+				t.Errorf("Message() = %q, want %q", got, tt.wantMessage)
+			}
+			if got := event.Time(); !got.Equal(tt.wantTime) {
+				t.Errorf("Time() = %v, want %v", got, tt.wantTime)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		detailType string
+		detail     string
+	}{
+		{name: "unsupported source", source: "aws.ec2", detailType: "EC2 Instance State-change Notification", detail: `{}`},
+		{name: "cloudtrail api call", source: "aws.rds", detailType: "AWS API Call via CloudTrail", detail: `{}`},
+		{name: "missing detail type", source: "aws.dms", detail: `{}`},
+		{name: "invalid rds detail", source: "aws.rds", detailType: "RDS DB Instance Event", detail: `[]`},
+		{name: "invalid dms detail", source: "aws.dms", detailType: "DMS Replication Task State Change", detail: `"x"`},
+		{name: "invalid elasticache detail", source: "aws.elasticache", detailType: "ElastiCache Snapshot Complete", detail: `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.source, tt.detailType, json.RawMessage(tt.detail), nil, time.Time{}); err == nil {
+				t.Errorf("Parse() returned no error")
+			}
+		})
+	}
+}
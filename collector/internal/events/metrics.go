@@ -0,0 +1,245 @@
+package events
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/truemark/database-collector/internal/aws"
+)
+
+// metricVec holds the series of an event metric. Counter series are collected
+// with the time of the event that last updated them, so samples line up with
+// when events happened rather than when they were delivered; an event
+// delivered after a later one is stamped just after it instead, so a higher
+// value never has an earlier time than a lower one. Gauge series are
+// collected without a time and so stamped when they are exported: a state is
+// pushed again by later invocations and must stay current. Only series
+// changed since the last call to Begin are collected: several containers
+// handle events concurrently and a series kept from an earlier event could be
+// older than the value another container has pushed since.
+type metricVec struct {
+	name       string
+	desc       *prometheus.Desc
+	valueType  prometheus.ValueType
+	labelNames []string
+
+	mutex  sync.Mutex
+	series map[string]*eventSeries
+}
+
+type eventSeries struct {
+	labelValues []string
+	value       float64
+	updated     time.Time
+	// changed is set when the series is updated by the current event
+	changed bool
+}
+
+var (
+	vecsMutex sync.Mutex
+	// vecs holds every event metric, so Begin can reset them
+	vecs []*metricVec
+)
+
+// deliveryWindow is how long EventBridge event ids are remembered to detect
+// repeated deliveries. EventBridge retries a delivery for up to 24 hours.
+const deliveryWindow = 24 * time.Hour
+
+// stateIDPrefix prefixes the ids of gauges stored in the state table, to
+// tell them apart from counters.
+const stateIDPrefix = "state/"
+
+// Begin starts handling an event: series changed by previous events are no
+// longer collected. With EVENTS_STATE_TABLE, the gauges stored by every
+// container are loaded and collected, so each invocation pushes the current
+// state of all sources; an error is returned if they cannot be loaded.
+func Begin() error {
+	vecsMutex.Lock()
+	defer vecsMutex.Unlock()
+
+	for _, v := range vecs {
+		v.mutex.Lock()
+		for _, series := range v.series {
+			series.changed = false
+		}
+		v.mutex.Unlock()
+	}
+
+	table := os.Getenv("EVENTS_STATE_TABLE")
+	if table == "" {
+		return nil
+	}
+	states, err := aws.ListStates(table, stateIDPrefix)
+	for _, state := range states {
+		name, _, _ := strings.Cut(strings.TrimPrefix(state.ID, stateIDPrefix), "{")
+		for _, v := range vecs {
+			if v.name != name || v.valueType != prometheus.GaugeValue {
+				continue
+			}
+			v.mutex.Lock()
+			series := v.getSeries(v.seriesID(state.LabelValues), state.LabelValues)
+			series.value = state.Value
+			series.changed = true
+			v.mutex.Unlock()
+		}
+	}
+	return err
+}
+
+// FirstDelivery reports whether the EventBridge event with the given id is
+// delivered for the first time. EventBridge delivers events at least once,
+// and counting a repeated delivery would count the event twice. With
+// EVENTS_STATE_TABLE the id is recorded with a conditional write, so repeats
+// handled by other containers are detected too. Events without an id, or
+// whose id cannot be recorded, are treated as first deliveries.
+func FirstDelivery(id string) (bool, error) {
+	if id == "" {
+		return true, nil
+	}
+	return firstInWindow("event/"+id, time.Now().Add(deliveryWindow), deliveryWindow)
+}
+
+func newMetricVec(name string, help string, valueType prometheus.ValueType, labelNames []string) *metricVec {
+	v := &metricVec{
+		name:       name,
+		desc:       prometheus.NewDesc(name, help, labelNames, nil),
+		valueType:  valueType,
+		labelNames: labelNames,
+		series:     make(map[string]*eventSeries),
+	}
+
+	vecsMutex.Lock()
+	defer vecsMutex.Unlock()
+	vecs = append(vecs, v)
+	return v
+}
+
+// getSeries returns the series with the given label values, creating it if
+// needed. The caller must hold the mutex.
+func (v *metricVec) getSeries(id string, labelValues []string) *eventSeries {
+	series, ok := v.series[id]
+	if !ok {
+		series = &eventSeries{labelValues: labelValues}
+		v.series[id] = series
+	}
+	return series
+}
+
+// seriesID identifies a series, e.g. name{a="1",b="2"}.
+func (v *metricVec) seriesID(labelValues []string) string {
+	pairs := make([]string, 0, len(v.labelNames))
+	for i, name := range v.labelNames {
+		value := ""
+		if i < len(labelValues) {
+			value = labelValues[i]
+		}
+		pairs = append(pairs, name+"=\""+value+"\"")
+	}
+	sort.Strings(pairs)
+	return v.name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Describe implements prometheus.Collector.
+func (v *metricVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+// Collect implements prometheus.Collector.
+func (v *metricVec) Collect(ch chan<- prometheus.Metric) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for _, series := range v.series {
+		if !series.changed {
+			continue
+		}
+		metric := prometheus.MustNewConstMetric(v.desc, v.valueType, series.value, series.labelValues...)
+		if v.valueType == prometheus.GaugeValue {
+			ch <- metric
+			continue
+		}
+		ch <- prometheus.NewMetricWithTimestamp(series.updated, metric)
+	}
+}
+
+// Counter is a counter vector whose values are persisted in the DynamoDB
+// table named by EVENTS_STATE_TABLE, so they keep increasing across Lambda
+// containers instead of resetting on every cold start. Without a table the
+// values are only kept in memory.
+type Counter struct {
+	*metricVec
+}
+
+// NewCounter returns a counter with the given name, help and label names.
+func NewCounter(name string, help string, labelNames []string) *Counter {
+	return &Counter{newMetricVec(name, help, prometheus.CounterValue, labelNames)}
+}
+
+// Inc increments the series with the given label values for an event that
+// happened at the given time. The persisted value and its time are updated
+// together, see aws.IncrementCounter. If the persisted value cannot be
+// updated the error is returned and the series is not collected, as the value
+// kept in memory may be behind the one pushed by other containers.
+func (c *Counter) Inc(at time.Time, labelValues ...string) error {
+	id := c.seriesID(labelValues)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	series := c.getSeries(id, labelValues)
+	if table := os.Getenv("EVENTS_STATE_TABLE"); table != "" {
+		value, updated, err := aws.IncrementCounter(table, id, 1, at)
+		if err != nil {
+			return err
+		}
+		series.value = value
+		series.updated = updated
+	} else {
+		series.value++
+		// An event delivered late must not put a higher value at an earlier
+		// time than the last sample
+		if at = at.Truncate(time.Millisecond); !at.After(series.updated) {
+			at = series.updated.Add(time.Millisecond)
+		}
+		series.updated = at
+	}
+	series.changed = true
+	return nil
+}
+
+// Gauge is a gauge vector set from events. Its values are stored in the
+// DynamoDB table named by EVENTS_STATE_TABLE and loaded by Begin, so every
+// container pushes the state set by any of them. Without a table a series is
+// only collected by the invocation that sets it.
+type Gauge struct {
+	*metricVec
+}
+
+// NewGauge returns a gauge with the given name, help and label names.
+func NewGauge(name string, help string, labelNames []string) *Gauge {
+	return &Gauge{newMetricVec(name, help, prometheus.GaugeValue, labelNames)}
+}
+
+// Set sets the series with the given label values for an event that happened
+// at the given time. If the value cannot be stored it is still collected by
+// this invocation and the error is returned.
+func (g *Gauge) Set(at time.Time, value float64, labelValues ...string) error {
+	id := g.seriesID(labelValues)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	series := g.getSeries(id, labelValues)
+	series.value = value
+	series.updated = at
+	series.changed = true
+
+	if table := os.Getenv("EVENTS_STATE_TABLE"); table != "" {
+		return aws.PutState(table, aws.State{ID: stateIDPrefix + id, LabelValues: labelValues, Value: value})
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// collected returns the value of each series collected from c by its first
+// label value.
+func collected(t *testing.T, c prometheus.Collector) map[string]float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 16)
+	c.Collect(ch)
+	close(ch)
+
+	values := make(map[string]float64)
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		value := m.GetCounter().GetValue()
+		if m.Gauge != nil {
+			value = m.GetGauge().GetValue()
+		}
+		values[m.GetLabel()[0].GetValue()] = value
+	}
+	return values
+}
+
+func TestCounterCollectsChangedSeries(t *testing.T) {
+	t.Setenv("EVENTS_STATE_TABLE", "")
+	counter := NewCounter("test_events_total", "Test events.", []string{"identifier"})
+	at := time.Unix(1700000000, 0)
+
+	steps := []struct {
+		name string
+		inc  []string
+		want map[string]float64
+	}{
+		{name: "first event", inc: []string{"orders"}, want: map[string]float64{"orders": 1}},
+		{name: "other source", inc: []string{"billing"}, want: map[string]float64{"billing": 1}},
+		{name: "same source again", inc: []string{"orders", "orders"}, want: map[string]float64{"orders": 3}},
+		{name: "no event", want: map[string]float64{}},
+	}
+	for _, step := range steps {
+		if err := Begin(); err != nil {
+			t.Fatal(err)
+		}
+		for _, identifier := range step.inc {
+			if err := counter.Inc(at, identifier); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := collected(t, counter)
+		if len(got) != len(step.want) {
+			t.Errorf("%s: collected %v, want %v", step.name, got, step.want)
+			continue
+		}
+		for identifier, value := range step.want {
+			if got[identifier] != value {
+				t.Errorf("%s: collected %v, want %v", step.name, got, step.want)
+			}
+		}
+	}
+}
+
+func TestCounterLateEventKeepsTimeIncreasing(t *testing.T) {
+	t.Setenv("EVENTS_STATE_TABLE", "")
+	counter := NewCounter("test_late_events_total", "Test events.", []string{"identifier"})
+	at := time.Unix(1700000000, 0)
+
+	var times []int64
+	for _, eventTime := range []time.Time{at, at.Add(-time.Minute), at.Add(time.Minute)} {
+		if err := counter.Inc(eventTime, "orders"); err != nil {
+			t.Fatal(err)
+		}
+		ch := make(chan prometheus.Metric, 1)
+		counter.Collect(ch)
+		m := &dto.Metric{}
+		if err := (<-ch).Write(m); err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, m.GetTimestampMs())
+	}
+	want := []int64{at.UnixMilli(), at.UnixMilli() + 1, at.Add(time.Minute).UnixMilli()}
+	if !reflect.DeepEqual(times, want) {
+		t.Errorf("sample times %v, want %v", times, want)
+	}
+}
+
+func TestGaugeWithoutStateTable(t *testing.T) {
+	t.Setenv("EVENTS_STATE_TABLE", "")
+	gauge := NewGauge("test_in_maintenance", "Test state.", []string{"identifier"})
+	at := time.Unix(1700000000, 0)
+
+	if err := Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gauge.Set(at, 1, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if got := collected(t, gauge); len(got) != 1 || got["orders"] != 1 {
+		t.Errorf("collected %v after Set, want orders=1", got)
+	}
+
+	// without a table, a state is only pushed by the invocation that sets it
+	if err := Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if got := collected(t, gauge); len(got) != 0 {
+		t.Errorf("collected %v by the next invocation, want none", got)
+	}
+}
+
+func TestFirstDelivery(t *testing.T) {
+	t.Setenv("EVENTS_STATE_TABLE", "")
+	for _, step := range []struct {
+		id   string
+		want bool
+	}{
+		{"7bf73129-1428-4cd3-a780-95db273d1602", true},
+		{"7bf73129-1428-4cd3-a780-95db273d1602", false},
+		{"a7d5e1c0-7a57-4f24-9a4a-0c1f1fd5b9d3", true},
+		{"", true},
+		{"", true},
+	} {
+		if first, err := FirstDelivery(step.id); err != nil || first != step.want {
+			t.Errorf("FirstDelivery(%q) = %v, %v, want %v", step.id, first, err, step.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package events
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RdsEventMessage is the detail of an RDS event delivered by EventBridge.
+type RdsEventMessage struct {
+	EventCategories  []string `json:"EventCategories"`
+	SourceType       string   `json:"SourceType"`
+	SourceArn        string   `json:"SourceArn"`
+	Date             string   `json:"Date"`
+	SourceIdentifier string   `json:"SourceIdentifier"`
+	Message          string   `json:"Message"`
+	EventID          string   `json:"EventID"`
+}
+
+// noEventID is the event ID label of events without an RDS event ID.
+const noEventID = "none"
+
+var rdsEventIDPattern = regexp.MustCompile(`RDS-EVENT-\d+`)
+
+// RdsEventID returns the RDS event ID, e.g. RDS-EVENT-0006, taken from the
+// EventID field or, for events that only mention it, from the message.
+func (e RdsEventMessage) RdsEventID() string {
+	for _, value := range []string{e.EventID, e.Message} {
+		if id := rdsEventIDPattern.FindString(value); id != "" {
+			return id
+		}
+	}
+	return noEventID
+}
+
+// Category returns the event categories, sorted and comma separated, so the
+// label has a small fixed set of values.
+func (e RdsEventMessage) Category() string {
+	categories := make([]string, 0, len(e.EventCategories))
+	for _, category := range e.EventCategories {
+		if category = strings.TrimSpace(category); category != "" {
+			categories = append(categories, strings.ToLower(category))
+		}
+	}
+	if len(categories) == 0 {
+		return noEventID
+	}
+	sort.Strings(categories)
+	return strings.Join(categories, ",")
+}
+
+// rdsSourceType describes one kind of RDS event source and its metric.
+type rdsSourceType struct {
+	// sourceType is the SourceType of the event detail
+	sourceType string
+	// detailType is the EventBridge detail-type of the event
+	detailType string
+	// arnResource is the resource type in the source ARN
+	arnResource string
+	counter     *Counter
+}
+
+// unknownSourceType is used for events of source types not listed below.
+const unknownSourceType = "UNKNOWN"
+
+// rdsEventLabels are the labels of the per source type event counters.
+var rdsEventLabels = []string{"identifier", "event_id", "event_category"}
+
+func newRdsEventCounter(name string, source string) *Counter {
+	return NewCounter(name, "Number of RDS events for "+source+".", rdsEventLabels)
+}
+
+var rdsSourceTypes = []*rdsSourceType{
+	{"DB_INSTANCE", "RDS DB Instance Event", "db", newRdsEventCounter("rds_instance_events_total", "DB instances")},
+	{"CLUSTER", "RDS DB Cluster Event", "cluster", newRdsEventCounter("rds_cluster_events_total", "DB clusters")},
+	{"SNAPSHOT", "RDS DB Snapshot Event", "snapshot", newRdsEventCounter("rds_snapshot_events_total", "DB snapshots")},
+	{"CLUSTER_SNAPSHOT", "RDS DB Cluster Snapshot Event", "cluster-snapshot", newRdsEventCounter("rds_cluster_snapshot_events_total", "DB cluster snapshots")},
+	{"DB_PARAM", "RDS DB Parameter Group Event", "pg", newRdsEventCounter("rds_parameter_group_events_total", "DB parameter groups")},
+	{"SECURITY_GROUP", "RDS DB Security Group Event", "secgrp", newRdsEventCounter("rds_security_group_events_total", "DB security groups")},
+	{"DB_PROXY", "RDS DB Proxy Event", "db-proxy", newRdsEventCounter("rds_proxy_events_total", "RDS Proxy")},
+	{"BLUE_GREEN_DEPLOYMENT", "RDS Blue Green Deployment Event", "deployment", newRdsEventCounter("rds_blue_green_deployment_events_total", "blue/green deployments")},
+}
+
+// RdsCollectors returns the per source type event counters for registration.
+func RdsCollectors() []prometheus.Collector {
+	collectors := make([]prometheus.Collector, 0, len(rdsSourceTypes))
+	for _, sourceType := range rdsSourceTypes {
+		collectors = append(collectors, sourceType.counter)
+	}
+	return collectors
+}
+
+// sourceType finds the source type of the event from its SourceType, its
+// EventBridge detail-type or its source ARN, in that order.
+func (e RdsEventMessage) sourceType(detailType string) *rdsSourceType {
+	for _, sourceType := range rdsSourceTypes {
+		if strings.EqualFold(e.SourceType, sourceType.sourceType) {
+			return sourceType
+		}
+	}
+	for _, sourceType := range rdsSourceTypes {
+		if detailType == sourceType.detailType {
+			return sourceType
+		}
+	}
+	if parsed, err := arn.Parse(e.SourceArn); err == nil {
+		resource, _, _ := strings.Cut(parsed.Resource, ":")
+		for _, sourceType := range rdsSourceTypes {
+			if resource == sourceType.arnResource {
+				return sourceType
+			}
+		}
+	}
+	return nil
+}
+
+// NormalizedSourceType returns the event's source type, e.g. DB_INSTANCE, or
+// UNKNOWN when it cannot be determined.
+func (e RdsEventMessage) NormalizedSourceType(detailType string) string {
+	if sourceType := e.sourceType(detailType); sourceType != nil {
+		return sourceType.sourceType
+	}
+	return unknownSourceType
+}
+
+// Identifier returns the name of the event's source, from SourceIdentifier or
+// otherwise from the resource of SourceArn after its type, e.g.
+// rds:db-2024-01-01 for arn:aws:rds:...:snapshot:rds:db-2024-01-01.
+func (e RdsEventMessage) Identifier() string {
+	if e.SourceIdentifier != "" {
+		return e.SourceIdentifier
+	}
+	if parsed, err := arn.Parse(e.SourceArn); err == nil {
+		parts := strings.SplitN(parsed.Resource, ":", 2)
+		return parts[len(parts)-1]
+	}
+	return ""
+}
+
+// Record counts the event in the counter of its source type. Events of an
+// unknown source type are only counted in rds_service_events.
+func (e RdsEventMessage) Record(detailType string, at time.Time) error {
+	if sourceType := e.sourceType(detailType); sourceType != nil {
+		return sourceType.counter.Inc(at, e.Identifier(), e.RdsEventID(), e.Category())
+	}
+	return nil
+}
+
+// Time returns when the event happened, from its Date, or otherwise from the
+// time of the EventBridge event that delivered it, or the current time.
+func (e RdsEventMessage) Time(delivered time.Time) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000Z0700"} {
+		if at, err := time.Parse(layout, e.Date); err == nil {
+			return at
+		}
+	}
+	if !delivered.IsZero() {
+		return delivered
+	}
+	return time.Now()
+}
@@ -0,0 +1,61 @@
+package events
+
+import "testing"
+
+func TestRdsEventMessageIdentifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		message RdsEventMessage
+		want    string
+	}{
+		{
+			name:    "source identifier",
+			message: RdsEventMessage{SourceIdentifier: "orders", SourceArn: "arn:aws:rds:us-east-1:123456789012:db:other"},
+			want:    "orders",
+		},
+		{
+			name:    "instance arn",
+			message: RdsEventMessage{SourceArn: "arn:aws:rds:us-east-1:123456789012:db:orders"},
+			want:    "orders",
+		},
+		{
+			name:    "automated snapshot arn",
+			message: RdsEventMessage{SourceArn: "arn:aws:rds:us-east-1:123456789012:snapshot:rds:orders-2024-01-01-00-00"},
+			want:    "rds:orders-2024-01-01-00-00",
+		},
+		{
+			name:    "invalid arn",
+			message: RdsEventMessage{SourceArn: "orders"},
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.message.Identifier(); got != tt.want {
+				t.Errorf("Identifier() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArnResource(t *testing.T) {
+	tests := []struct {
+		arn          string
+		wantResource string
+		wantName     string
+	}{
+		{"arn:aws:dms:us-east-1:123456789012:task:ABCDEF", "task", "ABCDEF"},
+		{"arn:aws:elasticache:us-east-1:123456789012:snapshot:automatic.orders-2024-01-01", "snapshot", "automatic.orders-2024-01-01"},
+		{"arn:aws:rds:us-east-1:123456789012:snapshot:rds:orders-2024-01-01", "snapshot", "rds:orders-2024-01-01"},
+		{"arn:aws:elasticache:us-east-1:123456789012:serverlesscache/orders", "serverlesscache", "orders"},
+		{"not an arn", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			resource, name := arnResource(tt.arn)
+			if resource != tt.wantResource || name != tt.wantName {
+				t.Errorf("arnResource() = %q, %q, want %q, %q", resource, name, tt.wantResource, tt.wantName)
+			}
+		})
+	}
+}
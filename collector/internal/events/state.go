@@ -0,0 +1,78 @@
+package events
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	lastFailover = NewGauge(
+		"rds_last_failover_timestamp_seconds",
+		"Time the last failover of the DB instance or cluster started.",
+		[]string{"identifier"},
+	)
+	lastReboot = NewGauge(
+		"rds_last_reboot_timestamp_seconds",
+		"Time the DB instance last restarted.",
+		[]string{"identifier"},
+	)
+	inMaintenance = NewGauge(
+		"rds_instance_in_maintenance",
+		"Whether offline maintenance of the DB instance is taking place (1) or not (0).",
+		[]string{"identifier"},
+	)
+	backupInProgress = NewGauge(
+		"rds_backup_in_progress",
+		"Whether a backup of the DB instance is in progress (1) or not (0).",
+		[]string{"identifier"},
+	)
+)
+
+// stateChange updates a state gauge of an event's source.
+type stateChange func(identifier string, at time.Time) error
+
+func setTimestamp(gauge *Gauge) stateChange {
+	return func(identifier string, at time.Time) error {
+		return gauge.Set(at, float64(at.Unix()), identifier)
+	}
+}
+
+func setValue(gauge *Gauge, value float64) stateChange {
+	return func(identifier string, at time.Time) error {
+		return gauge.Set(at, value, identifier)
+	}
+}
+
+// rdsStateChanges maps the RDS event IDs that mark the start or end of an
+// operation to the state they change.
+var rdsStateChanges = map[string]stateChange{
+	// Multi-AZ failover of a DB instance started
+	"RDS-EVENT-0013": setTimestamp(lastFailover),
+	// Same AZ and cross AZ failover of a DB cluster started
+	"RDS-EVENT-0072": setTimestamp(lastFailover),
+	"RDS-EVENT-0073": setTimestamp(lastFailover),
+	// The DB instance restarted
+	"RDS-EVENT-0006": setTimestamp(lastReboot),
+	// Offline maintenance of the DB instance started and completed
+	"RDS-EVENT-0026": setValue(inMaintenance, 1),
+	"RDS-EVENT-0027": setValue(inMaintenance, 0),
+	// Backup of the DB instance started and finished
+	"RDS-EVENT-0001": setValue(backupInProgress, 1),
+	"RDS-EVENT-0002": setValue(backupInProgress, 0),
+}
+
+// StateCollectors returns the state gauges for registration.
+func StateCollectors() []prometheus.Collector {
+	return []prometheus.Collector{lastFailover, lastReboot, inMaintenance, backupInProgress}
+}
+
+// RecordState updates the state gauges from an event that marks the start or
+// end of a failover, reboot, maintenance or backup. Other events are ignored.
+// An error is returned if the state cannot be stored.
+func (e RdsEventMessage) RecordState(at time.Time) error {
+	if change, ok := rdsStateChanges[e.RdsEventID()]; ok {
+		return change(e.Identifier(), at)
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/truemark/database-collector/internal/aws"
+	"github.com/truemark/database-collector/internal/utils"
+)
+
+// WebhookConfig lists the webhooks events are forwarded to. Environment
+// variables in the file are expanded, so webhook URLs holding secrets can be
+// passed in without writing them to disk.
+//
+//	dedupe_window: 30m
+//	webhooks:
+//	  - name: dba-slack
+//	    url: ${DBA_SLACK_WEBHOOK_URL}
+//	    format: slack
+//	    routes:
+//	      - severities: [critical]
+//	      - categories: [failover, maintenance]
+//	        identifiers: [prod-.*]
+//	  - name: team-a-teams
+//	    url: ${TEAM_A_TEAMS_WEBHOOK_URL}
+//	    format: teams
+//	    routes:
+//	      - labels:
+//	          team: a
+type WebhookConfig struct {
+	DedupeWindow time.Duration    `yaml:"dedupe_window"`
+	Webhooks     []*WebhookTarget `yaml:"webhooks"`
+}
+
+// WebhookTarget configures a single webhook. Format is json (default), slack
+// or teams. An event is sent when any of the routes matches it, or always
+// when there are no routes.
+type WebhookTarget struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Format  string            `yaml:"format"`
+	Headers map[string]string `yaml:"headers"`
+	Routes  []*WebhookRoute   `yaml:"routes"`
+}
+
+// WebhookRoute selects events. Every field that is set must match; a list
+// matches when any of its values does. Identifiers are regular expressions
+// matched against the whole identifier, labels are regular expressions
+// matched against the labels of the event's target, e.g. from its tags.
+type WebhookRoute struct {
+	Sources     []string          `yaml:"sources"`
+	Categories  []string          `yaml:"categories"`
+	Severities  []string          `yaml:"severities"`
+	EventIDs    []string          `yaml:"event_ids"`
+	Identifiers []string          `yaml:"identifiers"`
+	Labels      map[string]string `yaml:"labels"`
+
+	identifiers []*regexp.Regexp
+	labels      map[string]*regexp.Regexp
+}
+
+// Event severities, derived from the event categories.
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// severityCategories maps event categories to their severity. Categories not
+// listed are informational.
+var severityCategories = map[string]string{
+	"failure":           SeverityCritical,
+	"low storage":       SeverityCritical,
+	"failover":          SeverityWarning,
+	"availability":      SeverityWarning,
+	"maintenance":       SeverityWarning,
+	"recovery":          SeverityWarning,
+	"read replica":      SeverityWarning,
+	"deletion":          SeverityWarning,
+	"security":          SeverityWarning,
+	"security patching": SeverityWarning,
+}
+
+// Severity returns the highest severity of the event's categories.
+func Severity(event Event) string {
+	severity := SeverityInfo
+	for _, category := range strings.Split(event.Category(), ",") {
+		switch severityCategories[category] {
+		case SeverityCritical:
+			return SeverityCritical
+		case SeverityWarning:
+			severity = SeverityWarning
+		}
+	}
+	return severity
+}
+
+var (
+	webhookConfig     *WebhookConfig
+	webhookConfigErr  error
+	webhookConfigOnce sync.Once
+
+	// sent holds until when events sent per webhook are not sent again when
+	// there is no EVENTS_STATE_TABLE to share it between containers
+	sent      = make(map[string]time.Time)
+	sentMutex sync.Mutex
+
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// LoadWebhookConfig reads the webhooks from a YAML file.
+func LoadWebhookConfig(path string) (*WebhookConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook config: %w", err)
+	}
+	config, err := ParseWebhookConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// ParseWebhookConfig parses the webhooks from YAML.
+func ParseWebhookConfig(data []byte) (*WebhookConfig, error) {
+	config := &WebhookConfig{}
+	if err := yaml.UnmarshalStrict([]byte(os.ExpandEnv(string(data))), config); err != nil {
+		return nil, err
+	}
+	for i, webhook := range config.Webhooks {
+		if webhook.URL == "" {
+			return nil, fmt.Errorf("webhook %d has no url", i)
+		}
+		if webhook.Name == "" {
+			webhook.Name = fmt.Sprintf("webhook-%d", i)
+		}
+		switch webhook.Format {
+		case "":
+			webhook.Format = "json"
+		case "json", "slack", "teams":
+		default:
+			return nil, fmt.Errorf("webhook %s has unsupported format %q", webhook.Name, webhook.Format)
+		}
+		for _, route := range webhook.Routes {
+			for _, pattern := range route.Identifiers {
+				re, err := regexp.Compile("^(?:" + pattern + ")$")
+				if err != nil {
+					return nil, fmt.Errorf("webhook %s has invalid identifier pattern: %w", webhook.Name, err)
+				}
+				route.identifiers = append(route.identifiers, re)
+			}
+			route.labels = make(map[string]*regexp.Regexp)
+			for name, pattern := range route.Labels {
+				re, err := regexp.Compile("^(?:" + pattern + ")$")
+				if err != nil {
+					return nil, fmt.Errorf("webhook %s has invalid pattern for label %s: %w", webhook.Name, name, err)
+				}
+				route.labels[name] = re
+			}
+		}
+	}
+	return config, nil
+}
+
+// getWebhookConfig returns the webhooks from EVENTS_WEBHOOK_CONFIG_FILE, or
+// from the YAML in EVENTS_WEBHOOK_CONFIG, e.g. for a Lambda function without
+// a config file. It returns nil when neither is set.
+func getWebhookConfig() (*WebhookConfig, error) {
+	webhookConfigOnce.Do(func() {
+		if path := os.Getenv("EVENTS_WEBHOOK_CONFIG_FILE"); path != "" {
+			webhookConfig, webhookConfigErr = LoadWebhookConfig(path)
+		} else if data := os.Getenv("EVENTS_WEBHOOK_CONFIG"); data != "" {
+			webhookConfig, webhookConfigErr = ParseWebhookConfig([]byte(data))
+			if webhookConfigErr != nil {
+				webhookConfigErr = fmt.Errorf("failed to parse EVENTS_WEBHOOK_CONFIG: %w", webhookConfigErr)
+			}
+		}
+	})
+	return webhookConfig, webhookConfigErr
+}
+
+// matches reports whether the route selects the event of the target.
+func (r *WebhookRoute) matches(event Event, target utils.Target) bool {
+	if len(r.Sources) > 0 && !slices.Contains(r.Sources, event.Source()) {
+		return false
+	}
+	if len(r.Categories) > 0 && !slices.ContainsFunc(strings.Split(event.Category(), ","), func(category string) bool {
+		return slices.ContainsFunc(r.Categories, func(want string) bool { return strings.EqualFold(want, category) })
+	}) {
+		return false
+	}
+	if len(r.Severities) > 0 && !slices.Contains(r.Severities, Severity(event)) {
+		return false
+	}
+	if len(r.EventIDs) > 0 && !slices.Contains(r.EventIDs, event.EventID()) {
+		return false
+	}
+	if len(r.identifiers) > 0 && !slices.ContainsFunc(r.identifiers, func(re *regexp.Regexp) bool {
+		return re.MatchString(event.Identifier())
+	}) {
+		return false
+	}
+	for name, re := range r.labels {
+		if !re.MatchString(target.Labels[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// selects reports whether the webhook is sent the event of the target.
+func (w *WebhookTarget) selects(event Event, target utils.Target) bool {
+	if len(w.Routes) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(w.Routes, func(route *WebhookRoute) bool {
+		return route.matches(event, target)
+	})
+}
+
+// Forward posts the event to the configured webhooks whose routes select it. The target is the
+// database of the event from the target catalogue, if any. Repeats of an
+// event from the same source within the dedupe window are not sent again;
+// with EVENTS_STATE_TABLE set this holds across Lambda containers.
+func Forward(event Event, at time.Time, target utils.Target) error {
+	config, err := getWebhookConfig()
+	if err != nil || config == nil {
+		return err
+	}
+
+	var errs []error
+	for _, webhook := range config.Webhooks {
+		if !webhook.selects(event, target) {
+			continue
+		}
+		id := strings.Join([]string{"webhook", webhook.Name, event.Source(), event.Identifier(), event.EventID()}, "/")
+		until := time.Now().Add(config.DedupeWindow)
+		first, err := firstInWindow(id, until, config.DedupeWindow)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if !first {
+			continue
+		}
+		if err := webhook.post(event, at, target); err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s failed: %w", webhook.Name, err))
+			// let a retry or the next repeat of the event be sent
+			if err := releaseWindow(id, until, config.DedupeWindow); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// firstInWindow reports whether the event with the given dedupe id is the
+// first within the window, and if so marks it seen until the window ends.
+// Events are always first when the window is zero or their state cannot be
+// read.
+func firstInWindow(id string, until time.Time, window time.Duration) (bool, error) {
+	if window <= 0 {
+		return true, nil
+	}
+	if table := os.Getenv("EVENTS_STATE_TABLE"); table != "" {
+		acquired, err := aws.AcquireLease(table, id, until)
+		if err != nil {
+			return true, err
+		}
+		return acquired, nil
+	}
+
+	sentMutex.Lock()
+	defer sentMutex.Unlock()
+	now := time.Now()
+	for key, expires := range sent {
+		if !now.Before(expires) {
+			delete(sent, key)
+		}
+	}
+	if _, ok := sent[id]; ok {
+		return false, nil
+	}
+	sent[id] = until
+	return true, nil
+}
+
+// releaseWindow forgets that the event with the given dedupe id was sent,
+// after posting it failed.
+func releaseWindow(id string, until time.Time, window time.Duration) error {
+	if window <= 0 {
+		return nil
+	}
+	if table := os.Getenv("EVENTS_STATE_TABLE"); table != "" {
+		return aws.ReleaseLease(table, id, until)
+	}
+
+	sentMutex.Lock()
+	defer sentMutex.Unlock()
+	if sent[id].Equal(until) {
+		delete(sent, id)
+	}
+	return nil
+}
+
+func (w *WebhookTarget) post(event Event, at time.Time, target utils.Target) error {
+	var payload interface{}
+	switch w.Format {
+	case "slack":
+		payload = slackPayload(event, at, target)
+	case "teams":
+		payload = teamsPayload(event, at, target)
+	default:
+		payload = jsonPayload(event, at, target)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request failed with status: %d, %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// jsonPayload is the generic JSON representation of an event.
+func jsonPayload(event Event, at time.Time, target utils.Target) map[string]interface{} {
+	payload := map[string]interface{}{
+		"source":      event.Source(),
+		"event_id":    event.EventID(),
+		"category":    event.Category(),
+		"severity":    Severity(event),
+		"source_type": event.SourceType(),
+		"identifier":  event.Identifier(),
+		"source_arn":  event.SourceArn(),
+		"message":     event.Message(),
+		"time":        at.UTC().Format(time.RFC3339),
+	}
+	if target.Engine != "" {
+		payload["engine"] = target.Engine
+	}
+	if len(target.Labels) > 0 {
+		payload["labels"] = target.Labels
+	}
+	return payload
+}
+
+// title returns a one-line summary of the event.
+func title(event Event) string {
+	return fmt.Sprintf("[%s] %s %s: %s", strings.ToUpper(Severity(event)), event.SourceType(), event.Identifier(), event.EventID())
+}
+
+// Limits of the Slack Block Kit text fields.
+const (
+	slackHeaderLength  = 150
+	slackSectionLength = 3000
+)
+
+// message returns the message of the event, or a description of the event
+// for services whose events have none, e.g. some DMS and ElastiCache events.
+func message(event Event) string {
+	if message := strings.TrimSpace(event.Message()); message != "" {
+		return message
+	}
+	return fmt.Sprintf("%s event %s for %s %s.", event.Source(), event.EventID(), event.SourceType(), event.Identifier())
+}
+
+// truncate shortens text to at most length characters, ending it with an
+// ellipsis when shortened.
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-1]) + "…"
+}
+
+// facts returns the details shown below the event message.
+func facts(event Event, at time.Time, target utils.Target) [][2]string {
+	facts := [][2]string{
+		{"Source", event.Source()},
+		{"Category", event.Category()},
+		{"Time", at.UTC().Format(time.RFC3339)},
+	}
+	if target.Engine != "" {
+		facts = append(facts, [2]string{"Engine", target.Engine})
+	}
+	if event.SourceArn() != "" {
+		facts = append(facts, [2]string{"ARN", event.SourceArn()})
+	}
+	return facts
+}
+
+// slackPayload formats the event as a Slack Block Kit message. Texts are
+// truncated to the Block Kit limits, as Slack rejects longer ones.
+func slackPayload(event Event, at time.Time, target utils.Target) map[string]interface{} {
+	fields := make([]map[string]interface{}, 0)
+	for _, fact := range facts(event, at, target) {
+		fields = append(fields, map[string]interface{}{"type": "mrkdwn", "text": "*" + fact[0] + "*\n" + fact[1]})
+	}
+	return map[string]interface{}{
+		"text": title(event),
+		"blocks": []map[string]interface{}{
+			{"type": "header", "text": map[string]interface{}{"type": "plain_text", "text": truncate(title(event), slackHeaderLength)}},
+			{"type": "section", "text": map[string]interface{}{"type": "plain_text", "text": truncate(message(event), slackSectionLength)}},
+			{"type": "section", "fields": fields},
+		},
+	}
+}
+
+// teamsPayload formats the event as a Microsoft Teams Adaptive Card message.
+func teamsPayload(event Event, at time.Time, target utils.Target) map[string]interface{} {
+	factSet := make([]map[string]interface{}, 0)
+	for _, fact := range facts(event, at, target) {
+		factSet = append(factSet, map[string]interface{}{"title": fact[0], "value": fact[1]})
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []map[string]interface{}{
+					{"type": "TextBlock", "text": title(event), "weight": "Bolder", "size": "Medium", "wrap": true},
+					{"type": "TextBlock", "text": message(event), "wrap": true},
+					{"type": "FactSet", "facts": factSet},
+				},
+			},
+		}},
+	}
+}
@@ -0,0 +1,185 @@
+package events
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/truemark/database-collector/internal/utils"
+)
+
+func TestParseWebhookConfig(t *testing.T) {
+	t.Setenv("TEST_WEBHOOK_URL", "https://hooks.example.com/abc")
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+		check   func(t *testing.T, config *WebhookConfig)
+	}{
+		{
+			name: "defaults and env expansion",
+			yaml: "dedupe_window: 30m\nwebhooks:\n  - url: ${TEST_WEBHOOK_URL}\n",
+			check: func(t *testing.T, config *WebhookConfig) {
+				if config.DedupeWindow != 30*time.Minute {
+					t.Errorf("DedupeWindow = %v, want 30m", config.DedupeWindow)
+				}
+				webhook := config.Webhooks[0]
+				if webhook.URL != "https://hooks.example.com/abc" || webhook.Name != "webhook-0" || webhook.Format != "json" {
+					t.Errorf("webhook = %+v", webhook)
+				}
+			},
+		},
+		{
+			name: "compiled routes",
+			yaml: "webhooks:\n  - name: dba\n    url: https://example.com\n    format: slack\n    routes:\n      - identifiers: [prod-.*]\n        labels:\n          team: a|b\n",
+			check: func(t *testing.T, config *WebhookConfig) {
+				route := config.Webhooks[0].Routes[0]
+				if len(route.identifiers) != 1 || route.labels["team"] == nil {
+					t.Errorf("route = %+v", route)
+				}
+			},
+		},
+		{name: "missing url", yaml: "webhooks:\n  - name: dba\n", wantErr: "has no url"},
+		{name: "unsupported format", yaml: "webhooks:\n  - url: https://example.com\n    format: email\n", wantErr: "unsupported format"},
+		{name: "invalid identifier", yaml: "webhooks:\n  - url: https://example.com\n    routes:\n      - identifiers: ['(']\n", wantErr: "invalid identifier pattern"},
+		{name: "invalid label", yaml: "webhooks:\n  - url: https://example.com\n    routes:\n      - labels: {team: '('}\n", wantErr: "invalid pattern for label team"},
+		{name: "unknown field", yaml: "webhooks:\n  - url: https://example.com\n    channel: dba\n", wantErr: "channel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseWebhookConfig([]byte(tt.yaml))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseWebhookConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestWebhookSelects(t *testing.T) {
+	event := rdsEvent{message: RdsEventMessage{
+		EventCategories:  []string{"failover", "notification"},
+		SourceType:       "DB_INSTANCE",
+		SourceIdentifier: "prod-orders",
+		EventID:          "RDS-EVENT-0013",
+	}}
+	target := utils.Target{Labels: map[string]string{"team": "payments"}}
+
+	tests := []struct {
+		name   string
+		routes string
+		want   bool
+	}{
+		{name: "no routes", routes: "", want: true},
+		{name: "source", routes: "- sources: [aws.rds]", want: true},
+		{name: "other source", routes: "- sources: [aws.dms]", want: false},
+		{name: "category case insensitive", routes: "- categories: [Failover]", want: true},
+		{name: "other category", routes: "- categories: [backup]", want: false},
+		{name: "severity", routes: "- severities: [warning]", want: true},
+		{name: "other severity", routes: "- severities: [critical]", want: false},
+		{name: "event id", routes: "- event_ids: [RDS-EVENT-0013]", want: true},
+		{name: "identifier pattern", routes: "- identifiers: [prod-.*]", want: true},
+		{name: "identifier matches whole name", routes: "- identifiers: [prod]", want: false},
+		{name: "label", routes: "- labels: {team: pay.*}", want: true},
+		{name: "missing label", routes: "- labels: {env: prod}", want: false},
+		{name: "all fields must match", routes: "- sources: [aws.rds]\n  severities: [critical]", want: false},
+		{name: "any route may match", routes: "- severities: [critical]\n- event_ids: [RDS-EVENT-0013]", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := "webhooks:\n  - url: https://example.com\n"
+			if tt.routes != "" {
+				yaml += "    routes:\n      " + strings.ReplaceAll(tt.routes, "\n", "\n      ") + "\n"
+			}
+			config, err := ParseWebhookConfig([]byte(yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.Webhooks[0].selects(event, target); got != tt.want {
+				t.Errorf("selects() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirstInWindow(t *testing.T) {
+	t.Setenv("EVENTS_STATE_TABLE", "")
+	window := time.Minute
+	until := time.Now().Add(window)
+
+	if first, err := firstInWindow("webhook/a/first", until, window); err != nil || !first {
+		t.Fatalf("first event: firstInWindow() = %v, %v, want true", first, err)
+	}
+	if first, _ := firstInWindow("webhook/a/first", until, window); first {
+		t.Errorf("repeat: firstInWindow() = true, want false")
+	}
+
+	// a failed post releases the event so a retry is sent
+	if err := releaseWindow("webhook/a/first", until, window); err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := firstInWindow("webhook/a/first", until, window); !first {
+		t.Errorf("after release: firstInWindow() = false, want true")
+	}
+
+	// expired entries are pruned
+	sentMutex.Lock()
+	sent["webhook/a/expired"] = time.Now().Add(-time.Second)
+	sentMutex.Unlock()
+	if first, _ := firstInWindow("webhook/a/other", until, window); !first {
+		t.Errorf("other event: firstInWindow() = false, want true")
+	}
+	sentMutex.Lock()
+	_, kept := sent["webhook/a/expired"]
+	sentMutex.Unlock()
+	if kept {
+		t.Errorf("expired entry was not pruned")
+	}
+
+	if first, _ := firstInWindow("webhook/a/first", until, 0); !first {
+		t.Errorf("no window: firstInWindow() = false, want true")
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	tests := []struct {
+		name        string
+		event       Event
+		wantSection string
+	}{
+		{
+			name:        "message",
+			event:       rdsEvent{message: RdsEventMessage{SourceIdentifier: "orders", Message: "DB instance restarted"}},
+			wantSection: "DB instance restarted",
+		},
+		{
+			name:        "no message",
+			event:       elastiCacheEvent{message: map[string]interface{}{"cache-cluster-id": "sessions"}, detailType: "ElastiCache Cache Node Replaced"},
+			wantSection: "aws.elasticache event ElastiCache Cache Node Replaced for CACHE_CLUSTER sessions.",
+		},
+		{
+			name:        "long message",
+			event:       rdsEvent{message: RdsEventMessage{SourceIdentifier: strings.Repeat("o", 200), Message: strings.Repeat("é", 4000)}},
+			wantSection: strings.Repeat("é", slackSectionLength-1) + "…",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks := slackPayload(tt.event, time.Now(), utils.Target{})["blocks"].([]map[string]interface{})
+			header := blocks[0]["text"].(map[string]interface{})["text"].(string)
+			if utf8.RuneCountInString(header) > slackHeaderLength {
+				t.Errorf("header has %d characters, want at most %d", utf8.RuneCountInString(header), slackHeaderLength)
+			}
+			if section := blocks[1]["text"].(map[string]interface{})["text"]; section != tt.wantSection {
+				t.Errorf("section = %q, want %q", section, tt.wantSection)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/truemark/database-collector/internal/aws"
+)
+
+// LoadTarget reads a listed secret and returns its value and the target of
+// its database.
+func LoadTarget(secret *secretsmanager.SecretListEntry) (map[string]interface{}, Target, error) {
+	secretARN := *secret.ARN
+	value, err := aws.GetSecretValue(secretARN)
+	if err != nil {
+		return nil, Target{}, err
+	}
+	valueMap := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(value), &valueMap); err != nil {
+		return nil, Target{}, fmt.Errorf("error unmarshalling secret %s: %w", secretARN, err)
+	}
+	return valueMap, NewTarget(secretARN, aws.GetSecretTags(secret), valueMap), nil
+}
+
+// TargetCatalogue holds the targets of the databases discovered from tagged
+// secrets, so metrics that are not collected from the databases, such as
+// service events, can carry the same identifier, engine and labels as the
+// database metrics. It is refreshed on lookup once the interval has passed.
+type TargetCatalogue struct {
+	interval time.Duration
+
+	mutex     sync.Mutex
+	targets   []Target
+	refreshed time.Time
+}
+
+// NewTargetCatalogue returns a catalogue refreshed every interval.
+func NewTargetCatalogue(interval time.Duration) *TargetCatalogue {
+	return &TargetCatalogue{interval: interval}
+}
+
+// refresh lists the tagged secrets and rebuilds the targets. Secrets that
+// cannot be read are skipped, and the previous targets of sources that could
+// not be listed are kept. The caller must hold the mutex.
+func (c *TargetCatalogue) refresh() {
+	secrets, err := aws.ListSecrets()
+	var targets []Target
+	if err != nil {
+		fmt.Println("Error listing secrets of targets:", err)
+		var listErr *aws.ListSecretsError
+		if errors.As(err, &listErr) {
+			for _, target := range c.targets {
+				if listErr.Failed(target.AccountID, target.Region) {
+					targets = append(targets, target)
+				}
+			}
+		}
+	}
+	for _, secret := range secrets.SecretList {
+		_, target, err := LoadTarget(secret)
+		if err != nil {
+			fmt.Println("Error loading target of secret:", err)
+			continue
+		}
+		targets = append(targets, target)
+	}
+	c.targets = targets
+	c.refreshed = time.Now()
+}
+
+// Lookup returns the target with the given identifier. When sourceARN is an
+// ARN, e.g. of the resource an event is about, only targets in its region and
+// account match, as identifiers are only unique within them.
+func (c *TargetCatalogue) Lookup(identifier string, sourceARN string) (Target, bool) {
+	if identifier == "" {
+		return Target{}, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if time.Since(c.refreshed) >= c.interval {
+		c.refresh()
+	}
+
+	parsed, err := arn.Parse(sourceARN)
+	for _, target := range c.targets {
+		if target.Identifier != identifier {
+			continue
+		}
+		if err == nil && (target.Region != "" && target.Region != parsed.Region ||
+			target.AccountID != "" && target.AccountID != parsed.AccountID) {
+			continue
+		}
+		return target, true
+	}
+	return Target{}, false
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	ioprometheusclient "github.com/prometheus/client_model/go"
+)
+
+// defaultEMFNamespace is the CloudWatch namespace used when EMF_NAMESPACE is
+// not set.
+const defaultEMFNamespace = "DatabaseCollector"
+
+// defaultEMFMetrics is the curated set of metrics written as EMF when
+// EMF_METRICS is not set: availability, connections, replication lag and
+// slow queries.
+var defaultEMFMetrics = []string{
+	TargetUpMetricName,
+	"mysql_up",
+	"mysql_global_status_threads_connected",
+	"mysql_slave_status_seconds_behind_master",
+	"mysql_global_status_slow_queries",
+	"pg_up",
+	"pg_stat_database_numbackends",
+	"pg_replication_lag_seconds",
+	"oracledb_up",
+	"oracledb_sessions_value",
+}
+
+// ExportEMF writes the curated metrics of a target to stdout in CloudWatch
+// Embedded Metric Format, one document per series, with identifier and engine
+// as dimensions. The series' own labels are kept as properties of the
+// document. Counters are written as their cumulative value. Histograms and
+// summaries are not supported.
+func ExportEMF(metricFamilies []*ioprometheusclient.MetricFamily, target Target) error {
+	namespace := os.Getenv("EMF_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultEMFNamespace
+	}
+	selected := make(map[string]bool)
+	names := defaultEMFMetrics
+	if value := os.Getenv("EMF_METRICS"); value != "" {
+		names = strings.Split(value, ",")
+	}
+	for _, name := range names {
+		selected[strings.TrimSpace(name)] = true
+	}
+
+	// In Lambda, stdout is shipped to CloudWatch Logs, which extracts the metrics
+	encoder := json.NewEncoder(os.Stdout)
+	now := time.Now().UnixMilli()
+	for _, mf := range metricFamilies {
+		if !selected[mf.GetName()] {
+			continue
+		}
+		for _, m := range mf.Metric {
+			var value float64
+			switch mf.GetType() {
+			case ioprometheusclient.MetricType_COUNTER:
+				value = m.GetCounter().GetValue()
+			case ioprometheusclient.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case ioprometheusclient.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			timestamp := now
+			if m.GetTimestampMs() != 0 {
+				timestamp = m.GetTimestampMs()
+			}
+
+			document := make(map[string]interface{}, len(m.Label)+4)
+			for _, l := range m.Label {
+				document[l.GetName()] = l.GetValue()
+			}
+			// series labelled with their own identifier keep it, e.g. events
+			for name, value := range map[string]string{"identifier": target.Identifier, "engine": target.Engine} {
+				if _, exists := document[name]; !exists {
+					document[name] = value
+				}
+			}
+			document[mf.GetName()] = value
+			document["_aws"] = map[string]interface{}{
+				"Timestamp": timestamp,
+				"CloudWatchMetrics": []map[string]interface{}{{
+					"Namespace":  namespace,
+					"Dimensions": [][]string{{"identifier", "engine"}},
+					"Metrics":    []map[string]string{{"Name": mf.GetName()}},
+				}},
+			}
+			if err := encoder.Encode(document); err != nil {
+				return fmt.Errorf("failed to write EMF document: %w", err)
+			}
+		}
+	}
+	return nil
+}
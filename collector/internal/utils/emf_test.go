@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	ioprometheusclient "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// captureEMF runs ExportEMF and returns the documents it wrote to stdout.
+func captureEMF(t *testing.T, metricFamilies []*ioprometheusclient.MetricFamily, target Target) []map[string]interface{} {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	err = ExportEMF(metricFamilies, target)
+	os.Stdout = stdout
+	writer.Close()
+	if err != nil {
+		t.Fatalf("ExportEMF: %v", err)
+	}
+	var output bytes.Buffer
+	if _, err := io.Copy(&output, reader); err != nil {
+		t.Fatal(err)
+	}
+
+	var documents []map[string]interface{}
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		var document map[string]interface{}
+		if err := decoder.Decode(&document); err != nil {
+			t.Fatalf("invalid EMF document: %v", err)
+		}
+		documents = append(documents, document)
+	}
+	return documents
+}
+
+func TestExportEMF(t *testing.T) {
+	t.Setenv("EMF_NAMESPACE", "Test")
+	t.Setenv("EMF_METRICS", "pg_up, rds_instance_events_total")
+
+	metricFamilies := []*ioprometheusclient.MetricFamily{
+		{
+			Name: proto.String("pg_up"),
+			Type: ioprometheusclient.MetricType_GAUGE.Enum(),
+			Metric: []*ioprometheusclient.Metric{{
+				Label: []*ioprometheusclient.LabelPair{{Name: proto.String("server"), Value: proto.String("db1:5432")}},
+				Gauge: &ioprometheusclient.Gauge{Value: proto.Float64(1)},
+			}},
+		},
+		{
+			Name: proto.String("rds_instance_events_total"),
+			Type: ioprometheusclient.MetricType_COUNTER.Enum(),
+			Metric: []*ioprometheusclient.Metric{{
+				Label: []*ioprometheusclient.LabelPair{
+					{Name: proto.String("identifier"), Value: proto.String("other-db")},
+					{Name: proto.String("event_id"), Value: proto.String("RDS-EVENT-0006")},
+				},
+				Counter:     &ioprometheusclient.Counter{Value: proto.Float64(3)},
+				TimestampMs: proto.Int64(1700000000000),
+			}},
+		},
+		{
+			Name: proto.String("pg_stat_database_numbackends"),
+			Type: ioprometheusclient.MetricType_GAUGE.Enum(),
+			Metric: []*ioprometheusclient.Metric{{
+				Gauge: &ioprometheusclient.Gauge{Value: proto.Float64(12)},
+			}},
+		},
+	}
+
+	documents := captureEMF(t, metricFamilies, Target{Identifier: "db1", Engine: "postgres"})
+	if len(documents) != 2 {
+		t.Fatalf("got %d documents, want 2 for the selected metrics: %v", len(documents), documents)
+	}
+
+	gauge := documents[0]
+	for name, want := range map[string]interface{}{"pg_up": 1.0, "server": "db1:5432", "identifier": "db1", "engine": "postgres"} {
+		if gauge[name] != want {
+			t.Errorf("gauge document %s = %v, want %v", name, gauge[name], want)
+		}
+	}
+	aws, ok := gauge["_aws"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("gauge document has no _aws metadata: %v", gauge)
+	}
+	if timestamp, _ := aws["Timestamp"].(float64); timestamp <= 0 {
+		t.Errorf("gauge document Timestamp = %v, want the export time", aws["Timestamp"])
+	}
+	wantDirective := []interface{}{map[string]interface{}{
+		"Namespace":  "Test",
+		"Dimensions": []interface{}{[]interface{}{"identifier", "engine"}},
+		"Metrics":    []interface{}{map[string]interface{}{"Name": "pg_up"}},
+	}}
+	if !reflect.DeepEqual(aws["CloudWatchMetrics"], wantDirective) {
+		t.Errorf("gauge document CloudWatchMetrics = %v, want %v", aws["CloudWatchMetrics"], wantDirective)
+	}
+
+	// Series labelled with their own identifier keep it and their timestamp
+	counter := documents[1]
+	for name, want := range map[string]interface{}{"rds_instance_events_total": 3.0, "identifier": "other-db", "engine": "postgres", "event_id": "RDS-EVENT-0006"} {
+		if counter[name] != want {
+			t.Errorf("counter document %s = %v, want %v", name, counter[name], want)
+		}
+	}
+	if timestamp := counter["_aws"].(map[string]interface{})["Timestamp"]; timestamp != 1700000000000.0 {
+		t.Errorf("counter document Timestamp = %v, want the sample timestamp", timestamp)
+	}
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	ioprometheusclient "github.com/prometheus/client_model/go"
+)
+
+// ExportMetrics sends the gathered metric families of a target to every
+// exporter listed in METRICS_EXPORTERS, comma separated "remote_write" (the
+// default), "otlp" and "emf". A failing exporter does not stop the others.
+func ExportMetrics(metricFamilies []*ioprometheusclient.MetricFamily, target Target) error {
+	exporters := os.Getenv("METRICS_EXPORTERS")
+	if exporters == "" {
+		exporters = "remote_write"
+	}
+
+	var errs []error
+	for _, exporter := range strings.Split(exporters, ",") {
+		var err error
+		switch exporter = strings.TrimSpace(exporter); exporter {
+		case "remote_write":
+			err = ConvertMetricFamilyToTimeSeries(metricFamilies, target)
+		case "otlp":
+			err = ExportOTLP(metricFamilies, target)
+		case "emf":
+			err = ExportEMF(metricFamilies, target)
+		case "":
+			continue
+		default:
+			err = errors.New("unsupported exporter")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", exporter, err))
+		}
+	}
+	return errors.Join(errs...)
+}
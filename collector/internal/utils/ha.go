@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"os"
+	"sync"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	haLabels     []prompb.Label
+	haLabelsOnce sync.Once
+)
+
+// getHALabels returns the cluster and __replica__ labels used by the AMP and
+// Cortex HA tracker to deduplicate series sent by a pair of collectors. They
+// are only set when HA_CLUSTER is configured. The replica defaults to the
+// host name, which is unique per ECS task, and can be set with HA_REPLICA.
+func getHALabels() []prompb.Label {
+	haLabelsOnce.Do(func() {
+		cluster := os.Getenv("HA_CLUSTER")
+		if cluster == "" {
+			return
+		}
+		replica := os.Getenv("HA_REPLICA")
+		if replica == "" {
+			replica, _ = os.Hostname()
+		}
+		haLabels = []prompb.Label{
+			{Name: "cluster", Value: cluster},
+			{Name: "__replica__", Value: replica},
+		}
+	})
+	return haLabels
+}
+
+// setLabels sets labels on a series, replacing existing values.
+func setLabels(series []prompb.Label, labels []prompb.Label) []prompb.Label {
+	for _, label := range labels {
+		found := false
+		for i := range series {
+			if series[i].Name == label.Name {
+				series[i].Value = label.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			series = append(series, label)
+		}
+	}
+	return series
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	ioprometheusclient "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+	"google.golang.org/protobuf/proto"
+)
+
+// resetHALabels makes the next getHALabels call read the environment again.
+func resetHALabels(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		haLabels, haLabelsOnce = nil, sync.Once{}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestGetHALabels(t *testing.T) {
+	hostname, _ := os.Hostname()
+	tests := []struct {
+		name    string
+		cluster string
+		replica string
+		want    []prompb.Label
+	}{
+		{
+			name: "not configured",
+		},
+		{
+			name:    "cluster and replica",
+			cluster: "prod",
+			replica: "collector-a",
+			want:    []prompb.Label{{Name: "cluster", Value: "prod"}, {Name: "__replica__", Value: "collector-a"}},
+		},
+		{
+			name:    "replica defaults to the host name",
+			cluster: "prod",
+			want:    []prompb.Label{{Name: "cluster", Value: "prod"}, {Name: "__replica__", Value: hostname}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HA_CLUSTER", tt.cluster)
+			t.Setenv("HA_REPLICA", tt.replica)
+			resetHALabels(t)
+			if got := getHALabels(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getHALabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetLabels(t *testing.T) {
+	series := []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "from-series"}}
+	got := setLabels(series, []prompb.Label{{Name: "cluster", Value: "prod"}, {Name: "__replica__", Value: "a"}})
+	want := []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "prod"}, {Name: "__replica__", Value: "a"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("setLabels() = %v, want %v", got, want)
+	}
+}
+
+func TestHALabelsAreSetAfterRelabeling(t *testing.T) {
+	t.Setenv("HA_CLUSTER", "prod")
+	t.Setenv("HA_REPLICA", "collector-a")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCOUNT_ID", "123456789012")
+	resetHALabels(t)
+
+	// a rule dropping the HA labels must not stop them being sent
+	path := filepath.Join(t.TempDir(), "relabel.yaml")
+	if err := os.WriteFile(path, []byte(`
+metric_relabel_configs:
+  - regex: cluster|__replica__
+    action: labeldrop
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RELABEL_CONFIG_FILE", path)
+	resetRelabelRules := func() {
+		relabelRules, relabelRulesErr, relabelRulesOnce = nil, nil, sync.Once{}
+	}
+	resetRelabelRules()
+	t.Cleanup(resetRelabelRules)
+
+	server, received := newTestEndpoint(t, http.StatusOK)
+	useRemoteWriteConfig(t, `
+remote_write:
+  - name: amp
+    url: `+server.URL+`
+`)
+
+	metricFamilies := []*ioprometheusclient.MetricFamily{{
+		Name: proto.String("pg_up"),
+		Type: ioprometheusclient.MetricType_GAUGE.Enum(),
+		Metric: []*ioprometheusclient.Metric{{
+			Label: []*ioprometheusclient.LabelPair{{Name: proto.String("cluster"), Value: proto.String("from-series")}},
+			Gauge: &ioprometheusclient.Gauge{Value: proto.Float64(1)},
+		}},
+	}}
+	if err := ConvertMetricFamilyToTimeSeries(metricFamilies, Target{Identifier: "db1", Engine: "postgres"}); err != nil {
+		t.Fatalf("ConvertMetricFamilyToTimeSeries: %v", err)
+	}
+
+	requests := received()
+	if len(requests) != 1 || len(requests[0].series) != 1 {
+		t.Fatalf("got %v, want a single series", requests)
+	}
+	labels := make(map[string]string)
+	for _, label := range requests[0].series[0].Labels {
+		labels[label.Name] = label.Value
+	}
+	if labels["cluster"] != "prod" || labels["__replica__"] != "collector-a" {
+		t.Errorf("series labels = %v, want cluster prod and __replica__ collector-a", labels)
+	}
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/truemark/database-collector/internal/aws"
+)
+
+// labelTagPrefix marks secret tags that are copied into labels, e.g.
+// "database-collector:label:team=payments" becomes team="payments".
+const labelTagPrefix = "database-collector:label:"
+
+// reservedLabels are set by the collector itself and cannot be set by tags or
+// the secret, so a database cannot pass itself off as another job, region or
+// account.
+var reservedLabels = map[string]bool{
+	"job":         true,
+	"region":      true,
+	"accountId":   true,
+	"identifier":  true,
+	"engine":      true,
+	"cluster":     true,
+	"__replica__": true,
+}
+
+// defaultJobName is the job label used when JOB_NAME is not set.
+const defaultJobName = "database-collector"
+
+var (
+	externalLabels     map[string]string
+	externalLabelsOnce sync.Once
+)
+
+// ExternalLabels returns the labels added to every series regardless of the
+// target: job from JOB_NAME, region, accountId and any labels set in
+// EXTERNAL_LABELS as comma separated name=value pairs. Region and account ID
+// are looked up when not configured, and EXTERNAL_LABELS may override them.
+func ExternalLabels() map[string]string {
+	externalLabelsOnce.Do(func() {
+		externalLabels = map[string]string{
+			"job":       defaultJobName,
+			"region":    aws.GetRegion(),
+			"accountId": aws.GetAccountID(),
+		}
+		if jobName := os.Getenv("JOB_NAME"); jobName != "" {
+			externalLabels["job"] = jobName
+		}
+		for _, pair := range strings.Split(os.Getenv("EXTERNAL_LABELS"), ",") {
+			name, value, found := strings.Cut(pair, "=")
+			if name = strings.TrimSpace(name); found && name != "" {
+				externalLabels[SanitizeLabelName(name)] = strings.TrimSpace(value)
+			}
+		}
+	})
+	return externalLabels
+}
+
+// TargetLabels builds the static labels added to every series of a target.
+// Labels come from tags using labelTagPrefix, from the tags named in
+// LABEL_TAGS (comma separated, copied as-is) and from the optional "labels"
+// object in the secret JSON, which takes precedence over tags. Reserved
+// label names and names starting with "__" are dropped.
+func TargetLabels(tags map[string]string, secret map[string]interface{}) map[string]string {
+	labels := make(map[string]string)
+
+	selectedTags := make(map[string]bool)
+	for _, key := range strings.Split(os.Getenv("LABEL_TAGS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			selectedTags[key] = true
+		}
+	}
+
+	for key, value := range tags {
+		if strings.HasPrefix(key, labelTagPrefix) {
+			labels[SanitizeLabelName(strings.TrimPrefix(key, labelTagPrefix))] = value
+		} else if selectedTags[key] {
+			labels[SanitizeLabelName(key)] = value
+		}
+	}
+
+	if secretLabels, ok := secret["labels"].(map[string]interface{}); ok {
+		for key, value := range secretLabels {
+			labels[SanitizeLabelName(key)] = fmt.Sprint(value)
+		}
+	}
+
+	for name := range labels {
+		if name == "" || reservedLabels[name] || strings.HasPrefix(name, "__") {
+			delete(labels, name)
+		}
+	}
+	return labels
+}
+
+// SanitizeLabelName converts an arbitrary string, such as a tag key, into a
+// valid Prometheus label name.
+func SanitizeLabelName(name string) string {
+	var sb strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
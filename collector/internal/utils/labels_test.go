@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTargetLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		labelTags string
+		tags      map[string]string
+		secret    map[string]interface{}
+		want      map[string]string
+	}{
+		{
+			name: "prefixed tags",
+			tags: map[string]string{"database-collector:label:team": "payments", "owner": "dba"},
+			want: map[string]string{"team": "payments"},
+		},
+		{
+			name:      "selected tags",
+			labelTags: "owner, cost-center",
+			tags:      map[string]string{"owner": "dba", "cost-center": "42", "other": "x"},
+			want:      map[string]string{"owner": "dba", "cost_center": "42"},
+		},
+		{
+			name:   "secret labels take precedence",
+			tags:   map[string]string{"database-collector:label:team": "payments"},
+			secret: map[string]interface{}{"labels": map[string]interface{}{"team": "orders", "tier": 1.0}},
+			want:   map[string]string{"team": "orders", "tier": "1"},
+		},
+		{
+			name: "reserved labels are dropped",
+			tags: map[string]string{
+				"database-collector:label:job":        "other",
+				"database-collector:label:accountId":  "111111111111",
+				"database-collector:label:__name__":   "x",
+				"database-collector:label:team":       "payments",
+				"database-collector:label:identifier": "orders",
+			},
+			secret: map[string]interface{}{"labels": map[string]interface{}{"region": "us-east-1"}},
+			want:   map[string]string{"team": "payments"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LABEL_TAGS", tt.labelTags)
+			if got := TargetLabels(tt.tags, tt.secret); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TargetLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	tests := map[string]string{
+		"team":        "team",
+		"cost-center": "cost_center",
+		"1st":         "_1st",
+		"a.b/c":       "a_b_c",
+	}
+	for name, want := range tests {
+		if got := SanitizeLabelName(name); got != want {
+			t.Errorf("SanitizeLabelName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ioprometheusclient "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// scopeName is the instrumentation scope of every exported OTLP metric.
+const scopeName = "github.com/truemark/database-collector"
+
+var (
+	otlpConn     *grpc.ClientConn
+	otlpConnErr  error
+	otlpConnOnce sync.Once
+
+	// startTime is the start of cumulative OTLP sums and histograms that
+	// carry no created timestamp.
+	startTime = time.Now()
+)
+
+// ExportOTLP converts the gathered metric families of a target into OTLP
+// metrics and sends them to an OpenTelemetry collector. It uses the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_PROTOCOL ("http/protobuf",
+// the default, or "grpc") and OTEL_EXPORTER_OTLP_HEADERS variables. The
+// target's labels become resource attributes.
+func ExportOTLP(metricFamilies []*ioprometheusclient.MetricFamily, target Target) error {
+	rules, err := getRelabelRules()
+	if err != nil {
+		return err
+	}
+
+	request := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{
+				Attributes: resourceAttributes(target),
+			},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: scopeName},
+				Metrics: convertMetricFamiliesToOTLP(metricFamilies, rules.configs(target.Engine)),
+			}},
+		}},
+	}
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL") == "grpc" {
+		return sendOTLPGRPC(request)
+	}
+	return sendOTLPHTTP(request)
+}
+
+// resourceAttributes returns the target and external labels as resource
+// attributes. The job label is also reported as service.name.
+func resourceAttributes(target Target) []*commonpb.KeyValue {
+	attributes := make(map[string]string)
+	if target.Identifier != "" {
+		attributes["identifier"] = target.Identifier
+	}
+	if target.Engine != "" {
+		attributes["engine"] = target.Engine
+	}
+	if target.Region != "" {
+		attributes["region"] = target.Region
+	}
+	if target.AccountID != "" {
+		attributes["accountId"] = target.AccountID
+	}
+	for _, extra := range []map[string]string{target.Labels, ExternalLabels()} {
+		for name, value := range extra {
+			if _, exists := attributes[name]; !exists {
+				attributes[name] = value
+			}
+		}
+	}
+	attributes["service.name"] = attributes["job"]
+	return keyValues(attributes)
+}
+
+// otlpPoints holds the data points of one OTLP metric.
+type otlpPoints struct {
+	name            string
+	help            string
+	metricType      ioprometheusclient.MetricType
+	numberPoints    []*metricspb.NumberDataPoint
+	histogramPoints []*metricspb.HistogramDataPoint
+	summaryPoints   []*metricspb.SummaryDataPoint
+}
+
+// convertMetricFamiliesToOTLP maps Prometheus counters to monotonic sums,
+// gauges and untyped metrics to gauges, and histograms and summaries to their
+// OTLP equivalents. Relabel rules are applied to the metric name and labels
+// of every data point, and points are grouped by their relabeled name, so
+// metrics renamed with __name__ match those sent by remote write.
+func convertMetricFamiliesToOTLP(metricFamilies []*ioprometheusclient.MetricFamily, relabelConfigs []*relabel.Config) []*metricspb.Metric {
+	var metrics []*metricspb.Metric
+	now := uint64(time.Now().UnixNano())
+
+	for _, mf := range metricFamilies {
+		// points by relabeled name, in the order the names were first seen
+		var names []string
+		points := make(map[string]*otlpPoints)
+
+		for _, m := range mf.Metric {
+			name, attributes, keep := dataPointAttributes(mf.GetName(), m, relabelConfigs)
+			if !keep {
+				continue
+			}
+			p, ok := points[name]
+			if !ok {
+				p = &otlpPoints{name: name, help: mf.GetHelp(), metricType: mf.GetType()}
+				points[name] = p
+				names = append(names, name)
+			}
+			timestamp := now
+			if m.GetTimestampMs() != 0 {
+				timestamp = uint64(m.GetTimestampMs()) * uint64(time.Millisecond)
+			}
+
+			switch mf.GetType() {
+			case ioprometheusclient.MetricType_COUNTER:
+				p.numberPoints = append(p.numberPoints, &metricspb.NumberDataPoint{
+					Attributes:        attributes,
+					StartTimeUnixNano: startTimeUnixNano(m.GetCounter().GetCreatedTimestamp().AsTime()),
+					TimeUnixNano:      timestamp,
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: m.GetCounter().GetValue()},
+				})
+			case ioprometheusclient.MetricType_GAUGE:
+				p.numberPoints = append(p.numberPoints, &metricspb.NumberDataPoint{
+					Attributes:   attributes,
+					TimeUnixNano: timestamp,
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: m.GetGauge().GetValue()},
+				})
+			case ioprometheusclient.MetricType_UNTYPED:
+				p.numberPoints = append(p.numberPoints, &metricspb.NumberDataPoint{
+					Attributes:   attributes,
+					TimeUnixNano: timestamp,
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: m.GetUntyped().GetValue()},
+				})
+			case ioprometheusclient.MetricType_HISTOGRAM, ioprometheusclient.MetricType_GAUGE_HISTOGRAM:
+				p.histogramPoints = append(p.histogramPoints, histogramDataPoint(m.GetHistogram(), attributes, timestamp))
+			case ioprometheusclient.MetricType_SUMMARY:
+				p.summaryPoints = append(p.summaryPoints, summaryDataPoint(m.GetSummary(), attributes, timestamp))
+			}
+		}
+
+		for _, name := range names {
+			if metric := points[name].metric(); metric != nil {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	return metrics
+}
+
+// metric returns the OTLP metric of the points, or nil if there are none.
+func (p *otlpPoints) metric() *metricspb.Metric {
+	metric := &metricspb.Metric{
+		Name:        p.name,
+		Description: p.help,
+	}
+	switch {
+	case len(p.numberPoints) > 0 && p.metricType == ioprometheusclient.MetricType_COUNTER:
+		metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			DataPoints:             p.numberPoints,
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+		}}
+	case len(p.numberPoints) > 0:
+		metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: p.numberPoints}}
+	case len(p.histogramPoints) > 0:
+		metric.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			DataPoints:             p.histogramPoints,
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		}}
+	case len(p.summaryPoints) > 0:
+		metric.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{DataPoints: p.summaryPoints}}
+	default:
+		return nil
+	}
+	return metric
+}
+
+// dataPointAttributes applies relabel rules to a metric's name and labels and
+// returns the relabeled name and the remaining labels as attributes.
+func dataPointAttributes(name string, m *ioprometheusclient.Metric, relabelConfigs []*relabel.Config) (string, []*commonpb.KeyValue, bool) {
+	series := make([]prompb.Label, 0, len(m.Label)+1)
+	series = append(series, prompb.Label{Name: "__name__", Value: name})
+	for _, l := range m.Label {
+		series = append(series, prompb.Label{Name: l.GetName(), Value: l.GetValue()})
+	}
+	series, keep := relabelSeries(series, relabelConfigs)
+	if !keep {
+		return "", nil, false
+	}
+
+	attributes := make(map[string]string, len(series))
+	for _, l := range series {
+		if l.Name == "__name__" {
+			name = l.Value
+		} else {
+			attributes[l.Name] = l.Value
+		}
+	}
+	return name, keyValues(attributes), true
+}
+
+// histogramDataPoint converts cumulative Prometheus buckets into OTLP bucket
+// counts. The +Inf bucket is implied by the OTLP explicit bounds.
+func histogramDataPoint(h *ioprometheusclient.Histogram, attributes []*commonpb.KeyValue, timestamp uint64) *metricspb.HistogramDataPoint {
+	point := &metricspb.HistogramDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: startTimeUnixNano(h.GetCreatedTimestamp().AsTime()),
+		TimeUnixNano:      timestamp,
+		Count:             h.GetSampleCount(),
+		Sum:               proto.Float64(h.GetSampleSum()),
+	}
+	var previous uint64
+	for _, bucket := range h.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, bucket.GetCumulativeCount()-previous)
+		previous = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+	return point
+}
+
+func summaryDataPoint(s *ioprometheusclient.Summary, attributes []*commonpb.KeyValue, timestamp uint64) *metricspb.SummaryDataPoint {
+	point := &metricspb.SummaryDataPoint{
+		Attributes:        attributes,
+		StartTimeUnixNano: startTimeUnixNano(s.GetCreatedTimestamp().AsTime()),
+		TimeUnixNano:      timestamp,
+		Count:             s.GetSampleCount(),
+		Sum:               s.GetSampleSum(),
+	}
+	for _, quantile := range s.GetQuantile() {
+		point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+			Quantile: quantile.GetQuantile(),
+			Value:    quantile.GetValue(),
+		})
+	}
+	return point
+}
+
+// startTimeUnixNano returns the created timestamp, or the collector start
+// time when the metric has none.
+func startTimeUnixNano(created time.Time) uint64 {
+	if created.Unix() <= 0 {
+		created = startTime
+	}
+	return uint64(created.UnixNano())
+}
+
+// keyValues converts a map into attributes sorted by key.
+func keyValues(values map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, &commonpb.KeyValue{
+			Key:   key,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: values[key]}},
+		})
+	}
+	return attributes
+}
+
+// otlpHeaders parses OTEL_EXPORTER_OTLP_HEADERS, comma separated key=value
+// pairs with URL encoded values.
+func otlpHeaders() map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); !found || key == "" {
+			continue
+		}
+		if decoded, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = decoded
+		}
+		headers[key] = value
+	}
+	return headers
+}
+
+func sendOTLPHTTP(request *colmetricspb.ExportMetricsServiceRequest) error {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if endpoint == "" {
+			endpoint = "http://localhost:4318"
+		}
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/metrics"
+	}
+
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for name, value := range otlpHeaders() {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("OTLP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("OTLP request failed with status: %d, %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// getOTLPConn returns the gRPC connection to the OpenTelemetry collector,
+// created once. TLS is used for https endpoints.
+func getOTLPConn() (*grpc.ClientConn, error) {
+	otlpConnOnce.Do(func() {
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT")
+		if endpoint == "" {
+			endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		}
+		if endpoint == "" {
+			endpoint = "http://localhost:4317"
+		}
+		transport := insecure.NewCredentials()
+		if parsed, err := url.Parse(endpoint); err == nil && parsed.Host != "" {
+			if parsed.Scheme == "https" {
+				transport = credentials.NewClientTLSFromCert(nil, "")
+			}
+			endpoint = parsed.Host
+		}
+		otlpConn, otlpConnErr = grpc.NewClient(endpoint, grpc.WithTransportCredentials(transport))
+	})
+	return otlpConn, otlpConnErr
+}
+
+func sendOTLPGRPC(request *colmetricspb.ExportMetricsServiceRequest) error {
+	conn, err := getOTLPConn()
+	if err != nil {
+		return fmt.Errorf("failed to connect to OTLP endpoint: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, metadata.New(otlpHeaders()))
+
+	response, err := colmetricspb.NewMetricsServiceClient(conn).Export(ctx, request)
+	if err != nil {
+		return fmt.Errorf("OTLP request failed: %w", err)
+	}
+	if rejected := response.GetPartialSuccess().GetRejectedDataPoints(); rejected > 0 {
+		return fmt.Errorf("OTLP endpoint rejected %d data points: %s", rejected, response.GetPartialSuccess().GetErrorMessage())
+	}
+	return nil
+}
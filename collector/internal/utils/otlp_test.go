@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	ioprometheusclient "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHistogramDataPoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		buckets     [][2]float64 // upper bound, cumulative count
+		count       uint64
+		wantBounds  []float64
+		wantBuckets []uint64
+	}{
+		{
+			name:        "cumulative to per bucket counts",
+			buckets:     [][2]float64{{0.1, 2}, {0.5, 5}, {1, 9}},
+			count:       10,
+			wantBounds:  []float64{0.1, 0.5, 1},
+			wantBuckets: []uint64{2, 3, 4, 1},
+		},
+		{
+			name:        "explicit +Inf bucket is implied",
+			buckets:     [][2]float64{{1, 3}, {math.Inf(1), 4}},
+			count:       4,
+			wantBounds:  []float64{1},
+			wantBuckets: []uint64{3, 1},
+		},
+		{
+			name:        "no buckets",
+			count:       7,
+			wantBuckets: []uint64{7},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &ioprometheusclient.Histogram{
+				SampleCount: proto.Uint64(tt.count),
+				SampleSum:   proto.Float64(1.5),
+			}
+			for _, b := range tt.buckets {
+				h.Bucket = append(h.Bucket, &ioprometheusclient.Bucket{
+					UpperBound:      proto.Float64(b[0]),
+					CumulativeCount: proto.Uint64(uint64(b[1])),
+				})
+			}
+			point := histogramDataPoint(h, nil, 1)
+			if !reflect.DeepEqual(point.ExplicitBounds, tt.wantBounds) {
+				t.Errorf("bounds = %v, want %v", point.ExplicitBounds, tt.wantBounds)
+			}
+			if !reflect.DeepEqual(point.BucketCounts, tt.wantBuckets) {
+				t.Errorf("bucket counts = %v, want %v", point.BucketCounts, tt.wantBuckets)
+			}
+			if point.GetCount() != tt.count || point.GetSum() != 1.5 {
+				t.Errorf("count, sum = %d, %v", point.GetCount(), point.GetSum())
+			}
+		})
+	}
+}
+
+func TestConvertMetricFamiliesToOTLPRelabelsName(t *testing.T) {
+	counter := ioprometheusclient.MetricType_COUNTER
+	families := []*ioprometheusclient.MetricFamily{{
+		Name: proto.String("oracledb_sessions_value"),
+		Type: &counter,
+		Metric: []*ioprometheusclient.Metric{
+			{
+				Label:   []*ioprometheusclient.LabelPair{{Name: proto.String("status"), Value: proto.String("active")}},
+				Counter: &ioprometheusclient.Counter{Value: proto.Float64(3)},
+			},
+			{
+				Label:   []*ioprometheusclient.LabelPair{{Name: proto.String("status"), Value: proto.String("inactive")}},
+				Counter: &ioprometheusclient.Counter{Value: proto.Float64(4)},
+			},
+		},
+	}}
+	cfgs := parseRelabelConfigs(t, `
+- source_labels: [__name__, status]
+  regex: oracledb_sessions_value;active
+  target_label: __name__
+  replacement: oracledb_active_sessions
+`)
+
+	metrics := convertMetricFamiliesToOTLP(families, cfgs)
+	if len(metrics) != 2 {
+		t.Fatalf("metrics = %d, want 2", len(metrics))
+	}
+	want := []string{"oracledb_active_sessions", "oracledb_sessions_value"}
+	for i, metric := range metrics {
+		if metric.GetName() != want[i] {
+			t.Errorf("metric %d name = %s, want %s", i, metric.GetName(), want[i])
+		}
+		if points := metric.GetSum().GetDataPoints(); len(points) != 1 {
+			t.Errorf("metric %s has %d points, want 1", metric.GetName(), len(points))
+		}
+	}
+}
@@ -1,25 +1,24 @@
 package utils
 
 import (
-	"bytes"
-	"errors"
-	"fmt"
 	ioprometheusclient "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/prompb"
-	"io"
-	"net/http"
-	"os"
-	"strings"
+	"sort"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/aws/signer/v4"
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
 )
 
-func ConvertMetricFamilyToTimeSeries(metricFamilies []*ioprometheusclient.MetricFamily, identifier string, engine string) (*http.Response, error) {
+// ConvertMetricFamilyToTimeSeries converts the gathered metric families of a
+// target into time series and sends them to every remote write endpoint.
+func ConvertMetricFamilyToTimeSeries(metricFamilies []*ioprometheusclient.MetricFamily, target Target) error {
+	rules, err := getRelabelRules()
+	if err != nil {
+		return err
+	}
+	relabelConfigs := rules.configs(target.Engine)
+
 	var timeSeries []prompb.TimeSeries
 
 	for _, mf := range metricFamilies {
@@ -32,38 +31,50 @@ func ConvertMetricFamilyToTimeSeries(metricFamilies []*ioprometheusclient.Metric
 			}
 
 			ts := prompb.TimeSeries{}
-			labels := make([]prompb.Label, len(m.Label)+6)
-			labels[0] = prompb.Label{
+			labels := make([]prompb.Label, 0, len(m.Label)+5+len(target.Labels)+len(ExternalLabels()))
+			labels = append(labels, prompb.Label{
 				Name:  "__name__",
 				Value: mf.GetName(), // Assuming the metric name is stored here
-			}
-			for i, l := range m.Label {
-				labels[i+1] = prompb.Label{
+			})
+			for _, l := range m.Label {
+				labels = append(labels, prompb.Label{
 					Name:  l.GetName(),
 					Value: l.GetValue(),
-				}
+				})
 			}
-			labels[len(m.Label)+1] = prompb.Label{
-				Name:  "identifier",
-				Value: strings.Split(identifier, ".")[0],
-			}
-			labels[len(m.Label)+2] = prompb.Label{
-				Name:  "job",
-				Value: "database-collector",
-			}
-			labels[len(m.Label)+3] = prompb.Label{
-				Name:  "region",
-				Value: os.Getenv("AWS_REGION"),
+			// the target's labels are skipped when empty or already set by the
+			// series, e.g. events labelled with the database they concern
+			for _, l := range []prompb.Label{
+				{Name: "identifier", Value: target.Identifier},
+				{Name: "engine", Value: target.Engine},
+				{Name: "region", Value: target.Region},
+				{Name: "accountId", Value: target.AccountID},
+			} {
+				if l.Value != "" && !hasLabel(labels, l.Name) {
+					labels = append(labels, l)
+				}
 			}
-			labels[len(m.Label)+4] = prompb.Label{
-				Name:  "accountId",
-				Value: os.Getenv("AWS_ACCOUNT_ID"),
+			// add the target's static labels, then the external labels,
+			// unless the series already has them
+			for _, extra := range []map[string]string{target.Labels, ExternalLabels()} {
+				for name, value := range extra {
+					if !hasLabel(labels, name) {
+						labels = append(labels, prompb.Label{
+							Name:  name,
+							Value: value,
+						})
+					}
+				}
 			}
-			labels[len(m.Label)+5] = prompb.Label{
-				Name:  "engine",
-				Value: engine,
+			labels, keep := relabelSeries(labels, relabelConfigs)
+			if !keep {
+				continue
 			}
-			// add accountId and region as labels
+			// HA labels are set after relabeling so rules cannot drop them
+			labels = setLabels(labels, getHALabels())
+			sort.Slice(labels, func(i, j int) bool {
+				return labels[i].Name < labels[j].Name
+			})
 			ts.Labels = labels
 
 			var value float64
@@ -109,59 +120,22 @@ func ConvertMetricFamilyToTimeSeries(metricFamilies []*ioprometheusclient.Metric
 		}
 	}
 
-	writeRequest := &prompb.WriteRequest{
-		Timeseries: timeSeries,
-	}
-	body, err := encodeWriteRequestIntoProtoAndSnappy(writeRequest)
-	if err != nil {
-		return nil, err
-	}
-	return sendRequestToAPS(body)
+	return sendWriteRequest(timeSeries)
 }
 
-func encodeWriteRequestIntoProtoAndSnappy(writeRequest *prompb.WriteRequest) (*bytes.Reader, error) {
-	data, err := proto.Marshal(writeRequest)
-	if err != nil {
-		return nil, err
+func hasLabel(labels []prompb.Label, name string) bool {
+	for _, l := range labels {
+		if l.Name == name {
+			return true
+		}
 	}
-	encoded := snappy.Encode(nil, data)
-	return bytes.NewReader(encoded), nil
+	return false
 }
 
-func sendRequestToAPS(body *bytes.Reader) (*http.Response, error) {
-	remoteWriteURL := os.Getenv("PROMETHEUS_REMOTE_WRITE_URL")
-	if remoteWriteURL == "" {
-		return nil, errors.New("PROMETHEUS_REMOTE_WRITE_URL is not set")
-	}
-	req, err := http.NewRequest("POST", remoteWriteURL, body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create new request: %w", err)
-	}
-
-	sess, _ := session.NewSession(&aws.Config{
-		Region: aws.String(os.Getenv("AWS_REGION")),
-	})
-
-	signer := v4.NewSigner(sess.Config.Credentials)
-	_, err = signer.Sign(req, body, "aps", *sess.Config.Region, time.Now())
-	if err != nil {
-		return nil, fmt.Errorf("failed to sign the request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/x-protobuf")
-	req.Header.Set("Content-Encoding", "snappy")
-	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
-
-	resp, err := http.DefaultClient.Do(req)
+func encodeWriteRequestIntoProtoAndSnappy(writeRequest *prompb.WriteRequest) ([]byte, error) {
+	data, err := proto.Marshal(writeRequest)
 	if err != nil {
-		return nil, fmt.Errorf("request to APS failed: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		return nil, fmt.Errorf("request to AMP failed with status: %d, %s", resp.StatusCode, bodyString)
+		return nil, err
 	}
-
-	return resp, nil
+	return snappy.Encode(nil, data), nil
 }
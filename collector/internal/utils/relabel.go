@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v2"
+)
+
+// RelabelRules holds Prometheus style metric_relabel_configs applied to every
+// series before it is written. Global rules run first, followed by the rules
+// of the series' engine.
+//
+//	metric_relabel_configs:
+//	  - source_labels: [__name__]
+//	    regex: mysql_info_schema_.*
+//	    action: drop
+//	engines:
+//	  oracle:
+//	    metric_relabel_configs:
+//	      - regex: sid|serial
+//	        action: labeldrop
+type RelabelRules struct {
+	MetricRelabelConfigs []*relabel.Config             `yaml:"metric_relabel_configs"`
+	Engines              map[string]EngineRelabelRules `yaml:"engines"`
+}
+
+// EngineRelabelRules holds the relabel rules of a single engine.
+type EngineRelabelRules struct {
+	MetricRelabelConfigs []*relabel.Config `yaml:"metric_relabel_configs"`
+}
+
+var (
+	relabelRules     *RelabelRules
+	relabelRulesErr  error
+	relabelRulesOnce sync.Once
+)
+
+// LoadRelabelRules reads relabel rules from a YAML file.
+func LoadRelabelRules(path string) (*RelabelRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read relabel config: %w", err)
+	}
+	rules := &RelabelRules{}
+	if err := yaml.UnmarshalStrict(data, rules); err != nil {
+		return nil, fmt.Errorf("failed to parse relabel config %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// getRelabelRules returns the rules from RELABEL_CONFIG_FILE, loaded once.
+// It returns nil when no file is configured.
+func getRelabelRules() (*RelabelRules, error) {
+	relabelRulesOnce.Do(func() {
+		if path := os.Getenv("RELABEL_CONFIG_FILE"); path != "" {
+			relabelRules, relabelRulesErr = LoadRelabelRules(path)
+		}
+	})
+	return relabelRules, relabelRulesErr
+}
+
+// configs returns the global and engine rules in the order they are applied.
+// Engines without rules of their own use the rules of their family, e.g.
+// oracle-ee uses the oracle rules.
+func (r *RelabelRules) configs(engine string) []*relabel.Config {
+	if r == nil {
+		return nil
+	}
+	engineRules, ok := r.Engines[engine]
+	if !ok {
+		engineRules = r.Engines[EngineFamily(engine)]
+	}
+	cfgs := make([]*relabel.Config, 0, len(r.MetricRelabelConfigs)+len(engineRules.MetricRelabelConfigs))
+	cfgs = append(cfgs, r.MetricRelabelConfigs...)
+	return append(cfgs, engineRules.MetricRelabelConfigs...)
+}
+
+// relabelSeries applies cfgs to a series' labels. It returns false if the
+// series was dropped.
+func relabelSeries(series []prompb.Label, cfgs []*relabel.Config) ([]prompb.Label, bool) {
+	if len(cfgs) == 0 {
+		return series, true
+	}
+	lb := labels.NewScratchBuilder(len(series))
+	for _, l := range series {
+		lb.Add(l.Name, l.Value)
+	}
+	lb.Sort()
+	lbls, keep := relabel.Process(lb.Labels(), cfgs...)
+	if !keep {
+		return nil, false
+	}
+	result := make([]prompb.Label, 0, lbls.Len())
+	lbls.Range(func(l labels.Label) {
+		result = append(result, prompb.Label{Name: l.Name, Value: l.Value})
+	})
+	return result, true
+}
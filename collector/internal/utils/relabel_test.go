@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v2"
+)
+
+func parseRelabelConfigs(t *testing.T, data string) []*relabel.Config {
+	t.Helper()
+	var cfgs []*relabel.Config
+	if err := yaml.UnmarshalStrict([]byte(data), &cfgs); err != nil {
+		t.Fatalf("invalid relabel config: %v", err)
+	}
+	return cfgs
+}
+
+func TestRelabelSeries(t *testing.T) {
+	series := []prompb.Label{
+		{Name: "__name__", Value: "oracledb_sessions_value"},
+		{Name: "sid", Value: "42"},
+		{Name: "identifier", Value: "orders"},
+	}
+	tests := []struct {
+		name   string
+		config string
+		want   []prompb.Label
+		keep   bool
+	}{
+		{
+			name: "no rules",
+			want: series,
+			keep: true,
+		},
+		{
+			name: "drop by name",
+			config: `
+- source_labels: [__name__]
+  regex: oracledb_.*
+  action: drop`,
+			keep: false,
+		},
+		{
+			name: "keep by name",
+			config: `
+- source_labels: [__name__]
+  regex: oracledb_.*
+  action: keep`,
+			want: []prompb.Label{
+				{Name: "__name__", Value: "oracledb_sessions_value"},
+				{Name: "identifier", Value: "orders"},
+				{Name: "sid", Value: "42"},
+			},
+			keep: true,
+		},
+		{
+			name: "labeldrop",
+			config: `
+- regex: sid
+  action: labeldrop`,
+			want: []prompb.Label{
+				{Name: "__name__", Value: "oracledb_sessions_value"},
+				{Name: "identifier", Value: "orders"},
+			},
+			keep: true,
+		},
+		{
+			name: "rename metric",
+			config: `
+- source_labels: [__name__]
+  regex: oracledb_(.*)_value
+  target_label: __name__
+  replacement: oracle_$1`,
+			want: []prompb.Label{
+				{Name: "__name__", Value: "oracle_sessions"},
+				{Name: "identifier", Value: "orders"},
+				{Name: "sid", Value: "42"},
+			},
+			keep: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfgs []*relabel.Config
+			if tt.config != "" {
+				cfgs = parseRelabelConfigs(t, tt.config)
+			}
+			got, keep := relabelSeries(series, cfgs)
+			if keep != tt.keep {
+				t.Fatalf("keep = %v, want %v", keep, tt.keep)
+			}
+			if keep && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("labels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelabelRulesConfigs(t *testing.T) {
+	rules := &RelabelRules{}
+	if err := yaml.UnmarshalStrict([]byte(`
+metric_relabel_configs:
+  - regex: a
+    action: labeldrop
+engines:
+  oracle:
+    metric_relabel_configs:
+      - regex: b
+        action: labeldrop
+  custom-oracle-ee:
+    metric_relabel_configs:
+      - regex: c
+        action: labeldrop
+      - regex: d
+        action: labeldrop
+`), rules); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]int{
+		"oracle":           2,
+		"oracle-ee":        2, // the rules of the oracle family
+		"custom-oracle-ee": 3, // its own rules only
+		"mysql":            1,
+		"":                 1,
+	}
+	for engine, want := range tests {
+		if got := len(rules.configs(engine)); got != want {
+			t.Errorf("%q rules = %d, want %d", engine, got, want)
+		}
+	}
+	if got := (*RelabelRules)(nil).configs("oracle"); got != nil {
+		t.Errorf("nil rules = %v, want nil", got)
+	}
+}
@@ -0,0 +1,305 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"gopkg.in/yaml.v2"
+
+	collectoraws "github.com/truemark/database-collector/internal/aws"
+)
+
+// RemoteWriteConfig lists the endpoints every write request is sent to.
+// Environment variables in the file are expanded, so credentials can be
+// passed in without writing them to disk.
+//
+//	remote_write:
+//	  - name: amp
+//	    url: https://aps-workspaces.us-west-2.amazonaws.com/workspaces/ws-1/api/v1/remote_write
+//	    sigv4:
+//	      region: us-west-2
+//	      role_arn: arn:aws:iam::111111111111:role/amp-writer
+//	      external_id: database-collector
+//	  - name: mimir
+//	    url: https://mimir.example.com/api/v1/push
+//	    basic_auth:
+//	      username: collector
+//	      password: ${MIMIR_PASSWORD}
+//	    headers:
+//	      X-Scope-OrgID: databases
+//	    write_relabel_configs:
+//	      - source_labels: [__name__]
+//	        regex: oracledb_.*
+//	        action: keep
+type RemoteWriteConfig struct {
+	RemoteWrite []*EndpointConfig `yaml:"remote_write"`
+}
+
+// EndpointConfig configures a single remote write endpoint. At most one of
+// SigV4, BasicAuth and BearerToken is used, in that order.
+type EndpointConfig struct {
+	Name                string            `yaml:"name"`
+	URL                 string            `yaml:"url"`
+	SigV4               *SigV4Config      `yaml:"sigv4"`
+	BasicAuth           *BasicAuthConfig  `yaml:"basic_auth"`
+	BearerToken         string            `yaml:"bearer_token"`
+	Headers             map[string]string `yaml:"headers"`
+	WriteRelabelConfigs []*relabel.Config `yaml:"write_relabel_configs"`
+}
+
+// SigV4Config configures AWS request signing. Region defaults to the
+// collector's region and Service to "aps". When RoleARN is set the request
+// is signed with credentials of that role, e.g. to write to a workspace in
+// another account.
+type SigV4Config struct {
+	Region     string `yaml:"region"`
+	Service    string `yaml:"service"`
+	RoleARN    string `yaml:"role_arn"`
+	ExternalID string `yaml:"external_id"`
+}
+
+// BasicAuthConfig configures HTTP basic authentication.
+type BasicAuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// endpoint is a configured remote write endpoint ready to send requests.
+type endpoint struct {
+	config *EndpointConfig
+	signer *v4.Signer
+	spool  *spool
+}
+
+// nonRecoverableError is returned for requests the endpoint rejected and
+// would reject again, so they are not spooled.
+type nonRecoverableError struct {
+	error
+}
+
+func (e nonRecoverableError) Unwrap() error {
+	return e.error
+}
+
+// isRecoverable reports whether a failed request may succeed when retried.
+func isRecoverable(err error) bool {
+	return !errors.As(err, &nonRecoverableError{})
+}
+
+var (
+	endpoints     []*endpoint
+	endpointsErr  error
+	endpointsOnce sync.Once
+)
+
+// LoadRemoteWriteConfig reads the remote write endpoints from a YAML file.
+func LoadRemoteWriteConfig(path string) (*RemoteWriteConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote write config: %w", err)
+	}
+	config := &RemoteWriteConfig{}
+	if err := yaml.UnmarshalStrict([]byte(os.ExpandEnv(string(data))), config); err != nil {
+		return nil, fmt.Errorf("failed to parse remote write config %s: %w", path, err)
+	}
+	for i, endpointConfig := range config.RemoteWrite {
+		if endpointConfig.URL == "" {
+			return nil, fmt.Errorf("remote write endpoint %d has no url", i)
+		}
+		if endpointConfig.Name == "" {
+			endpointConfig.Name = endpointConfig.URL
+		}
+	}
+	return config, nil
+}
+
+// getEndpoints returns the endpoints from REMOTE_WRITE_CONFIG_FILE, or a single
+// SigV4 signed endpoint for PROMETHEUS_REMOTE_WRITE_URL when no file is set.
+// That endpoint assumes PROMETHEUS_REMOTE_WRITE_ROLE_ARN, with the optional
+// PROMETHEUS_REMOTE_WRITE_EXTERNAL_ID, when set.
+func getEndpoints() ([]*endpoint, error) {
+	endpointsOnce.Do(func() {
+		config := &RemoteWriteConfig{}
+		if path := os.Getenv("REMOTE_WRITE_CONFIG_FILE"); path != "" {
+			config, endpointsErr = LoadRemoteWriteConfig(path)
+			if endpointsErr != nil {
+				return
+			}
+		} else if remoteWriteURL := os.Getenv("PROMETHEUS_REMOTE_WRITE_URL"); remoteWriteURL != "" {
+			config.RemoteWrite = []*EndpointConfig{{
+				Name: "default",
+				URL:  remoteWriteURL,
+				SigV4: &SigV4Config{
+					RoleARN:    os.Getenv("PROMETHEUS_REMOTE_WRITE_ROLE_ARN"),
+					ExternalID: os.Getenv("PROMETHEUS_REMOTE_WRITE_EXTERNAL_ID"),
+				},
+			}}
+		}
+		if len(config.RemoteWrite) == 0 {
+			endpointsErr = errors.New("no remote write endpoint configured, set PROMETHEUS_REMOTE_WRITE_URL or REMOTE_WRITE_CONFIG_FILE")
+			return
+		}
+		for _, endpointConfig := range config.RemoteWrite {
+			var e *endpoint
+			e, endpointsErr = newEndpoint(endpointConfig)
+			if endpointsErr != nil {
+				return
+			}
+			endpoints = append(endpoints, e)
+		}
+	})
+	return endpoints, endpointsErr
+}
+
+func newEndpoint(config *EndpointConfig) (*endpoint, error) {
+	e := &endpoint{config: config}
+	if config.SigV4 != nil {
+		if config.SigV4.Region == "" {
+			config.SigV4.Region = collectoraws.GetRegion()
+		}
+		if config.SigV4.Service == "" {
+			config.SigV4.Service = "aps"
+		}
+		sess := session.Must(session.NewSession(&aws.Config{
+			Region: aws.String(config.SigV4.Region),
+		}))
+		credentials := sess.Config.Credentials
+		if config.SigV4.RoleARN != "" {
+			credentials = collectoraws.AssumeRoleCredentials(sess, config.SigV4.RoleARN, config.SigV4.ExternalID)
+		}
+		e.signer = v4.NewSigner(credentials)
+	}
+	var err error
+	e.spool, err = newSpool(config.Name)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// sendWriteRequest sends the time series to every endpoint. A failing
+// endpoint does not stop delivery to the others; all errors are returned.
+func sendWriteRequest(timeSeries []prompb.TimeSeries) error {
+	endpoints, err := getEndpoints()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, e := range endpoints {
+		if err := e.write(timeSeries); err != nil {
+			errs = append(errs, fmt.Errorf("remote write to %s failed: %w", e.config.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// write applies the endpoint's relabel rules and sends the remaining series.
+// With spooling enabled, spooled requests are replayed before the request is
+// sent, and a request that fails but may succeed later is spooled.
+func (e *endpoint) write(timeSeries []prompb.TimeSeries) error {
+	if len(e.config.WriteRelabelConfigs) > 0 {
+		relabeled := make([]prompb.TimeSeries, 0, len(timeSeries))
+		for _, ts := range timeSeries {
+			labels, keep := relabelSeries(ts.Labels, e.config.WriteRelabelConfigs)
+			if !keep {
+				continue
+			}
+			relabeled = append(relabeled, prompb.TimeSeries{Labels: labels, Samples: ts.Samples})
+		}
+		timeSeries = relabeled
+	}
+
+	body, err := encodeWriteRequestIntoProtoAndSnappy(&prompb.WriteRequest{
+		Timeseries: timeSeries,
+	})
+	if err != nil {
+		return err
+	}
+
+	if e.spool == nil {
+		return e.send(bytes.NewReader(body))
+	}
+
+	// Spooled requests are older, so they are replayed first to keep the
+	// samples in order. Replay errors are only logged, they do not concern
+	// the request being written.
+	pending, err := e.spool.replay(e.send)
+	if err != nil {
+		slog.Warn("Failed to replay spooled requests", "endpoint", e.config.Name, "error", err)
+	}
+	if pending {
+		// The endpoint is still failing or another write is replaying, queue
+		// the request behind the others
+		if spoolErr := e.spool.store(body); spoolErr != nil {
+			return errors.Join(err, spoolErr)
+		}
+		if err == nil {
+			return errors.New("request spooled behind a replay in progress")
+		}
+		return fmt.Errorf("request spooled behind earlier requests: %w", err)
+	}
+
+	err = e.send(bytes.NewReader(body))
+	if err != nil && isRecoverable(err) {
+		if spoolErr := e.spool.store(body); spoolErr != nil {
+			return errors.Join(err, spoolErr)
+		}
+	}
+	return err
+}
+
+func (e *endpoint) send(body *bytes.Reader) error {
+	req, err := http.NewRequest("POST", e.config.URL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create new request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for name, value := range e.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	switch {
+	case e.signer != nil:
+		_, err = e.signer.Sign(req, body, e.config.SigV4.Service, e.config.SigV4.Region, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to sign the request: %w", err)
+		}
+	case e.config.BasicAuth != nil:
+		req.SetBasicAuth(e.config.BasicAuth.Username, e.config.BasicAuth.Password)
+	case e.config.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+e.config.BearerToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		err = fmt.Errorf("request failed with status: %d, %s", resp.StatusCode, string(bodyBytes))
+		// Other client errors, e.g. samples out of the ingestion window, fail again on retry
+		if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+			return nonRecoverableError{err}
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// receivedRequest is a write request received by a test endpoint.
+type receivedRequest struct {
+	header http.Header
+	series []prompb.TimeSeries
+}
+
+// newTestEndpoint starts a remote write endpoint that records the requests
+// it receives and answers with status.
+func newTestEndpoint(t *testing.T, status int) (*httptest.Server, func() []receivedRequest) {
+	t.Helper()
+	var mutex sync.Mutex
+	var received []receivedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, body)
+		request := &prompb.WriteRequest{}
+		if err == nil {
+			err = request.Unmarshal(data)
+		}
+		if err != nil {
+			t.Errorf("invalid write request: %v", err)
+		}
+		mutex.Lock()
+		received = append(received, receivedRequest{header: r.Header.Clone(), series: request.Timeseries})
+		mutex.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []receivedRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return received
+	}
+}
+
+// useRemoteWriteConfig configures the endpoints of a test from YAML and
+// resets them when the test ends.
+func useRemoteWriteConfig(t *testing.T, config string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "remote-write.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REMOTE_WRITE_CONFIG_FILE", path)
+	t.Setenv("SPOOL_DIR", "")
+	reset := func() {
+		endpoints, endpointsErr, endpointsOnce = nil, nil, sync.Once{}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestSendWriteRequestToEveryEndpoint(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("MIMIR_PASSWORD", "s3cret")
+
+	failing, failingReceived := newTestEndpoint(t, http.StatusInternalServerError)
+	amp, ampReceived := newTestEndpoint(t, http.StatusOK)
+	mimir, mimirReceived := newTestEndpoint(t, http.StatusOK)
+	other, otherReceived := newTestEndpoint(t, http.StatusNoContent)
+
+	// the failing endpoint comes first, the others must still be written to
+	useRemoteWriteConfig(t, `
+remote_write:
+  - name: failing
+    url: `+failing.URL+`
+  - name: amp
+    url: `+amp.URL+`
+    sigv4:
+      region: eu-west-1
+  - name: mimir
+    url: `+mimir.URL+`
+    basic_auth:
+      username: collector
+      password: ${MIMIR_PASSWORD}
+    headers:
+      X-Scope-OrgID: databases
+  - name: other
+    url: `+other.URL+`
+    bearer_token: token-1
+    write_relabel_configs:
+      - source_labels: [__name__]
+        regex: up
+        action: drop
+`)
+
+	timeSeries := []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}, Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "mysql_global_status_uptime"}}, Samples: []prompb.Sample{{Value: 42, Timestamp: 1000}}},
+	}
+	err := sendWriteRequest(timeSeries)
+	if err == nil || !strings.Contains(err.Error(), "remote write to failing failed") {
+		t.Fatalf("sendWriteRequest() error = %v, want the failing endpoint's error", err)
+	}
+	for _, name := range []string{"amp", "mimir", "other"} {
+		if strings.Contains(err.Error(), "remote write to "+name) {
+			t.Errorf("sendWriteRequest() error = %v, want no error for %s", err, name)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		received   func() []receivedRequest
+		wantSeries int
+		check      func(t *testing.T, header http.Header)
+	}{
+		{
+			name:       "failing",
+			received:   failingReceived,
+			wantSeries: 2,
+			check: func(t *testing.T, header http.Header) {
+				if auth := header.Get("Authorization"); auth != "" {
+					t.Errorf("Authorization = %q, want none", auth)
+				}
+			},
+		},
+		{
+			name:       "sigv4",
+			received:   ampReceived,
+			wantSeries: 2,
+			check: func(t *testing.T, header http.Header) {
+				auth := header.Get("Authorization")
+				if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/eu-west-1/aps/aws4_request") {
+					t.Errorf("Authorization = %q, want a SigV4 signature for aps in eu-west-1", auth)
+				}
+				if header.Get("X-Amz-Date") == "" {
+					t.Errorf("X-Amz-Date is not set")
+				}
+			},
+		},
+		{
+			name:       "basic auth and headers",
+			received:   mimirReceived,
+			wantSeries: 2,
+			check: func(t *testing.T, header http.Header) {
+				request := &http.Request{Header: header}
+				if username, password, ok := request.BasicAuth(); !ok || username != "collector" || password != "s3cret" {
+					t.Errorf("basic auth = %q, %q, want collector and the expanded password", username, password)
+				}
+				if org := header.Get("X-Scope-OrgID"); org != "databases" {
+					t.Errorf("X-Scope-OrgID = %q, want databases", org)
+				}
+			},
+		},
+		{
+			name:       "bearer token and write relabeling",
+			received:   otherReceived,
+			wantSeries: 1,
+			check: func(t *testing.T, header http.Header) {
+				if auth := header.Get("Authorization"); auth != "Bearer token-1" {
+					t.Errorf("Authorization = %q, want Bearer token-1", auth)
+				}
+				if org := header.Get("X-Scope-OrgID"); org != "" {
+					t.Errorf("X-Scope-OrgID = %q, want the headers of its own endpoint only", org)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := tt.received()
+			if len(received) != 1 {
+				t.Fatalf("received %d requests, want 1", len(received))
+			}
+			if got := len(received[0].series); got != tt.wantSeries {
+				t.Errorf("received %d series, want %d", got, tt.wantSeries)
+			}
+			header := received[0].header
+			if header.Get("Content-Encoding") != "snappy" || header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+				t.Errorf("headers = %v, want remote write headers", header)
+			}
+			tt.check(t, header)
+		})
+	}
+}
+
+func TestLoadRemoteWriteConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{name: "valid", config: "remote_write:\n  - url: https://example.com/push\n"},
+		{name: "missing url", config: "remote_write:\n  - name: amp\n", wantErr: "has no url"},
+		{name: "unknown field", config: "remote_write:\n  - url: https://example.com\n    token: x\n", wantErr: "token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "remote-write.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadRemoteWriteConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadRemoteWriteConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the name defaults to the url
+			if config.RemoteWrite[0].Name != "https://example.com/push" {
+				t.Errorf("name = %q, want the url", config.RemoteWrite[0].Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/truemark/database-collector/internal/aws"
+)
+
+// Shard is the part of the fleet a collector is responsible for when
+// several collectors run side by side. Shards are either numbered, Index out
+// of Count, or identified by a stable Member ID among Members, e.g. the ARNs
+// of the tasks of an ECS service. The zero Shard owns nothing, it stands for
+// a shard that has not been resolved yet.
+type Shard struct {
+	Index   int
+	Count   int
+	Member  string
+	Members []string
+}
+
+// GetShard returns the collector's shard. SHARD_INDEX and SHARD_COUNT set it
+// explicitly. With SHARDING=ecs the shard is identified by the task's ARN
+// among the running tasks of its ECS service, so it follows the service's
+// task count and replacing a task only moves the targets of that task.
+// Without either, the collector owns every target.
+func GetShard() (Shard, error) {
+	if os.Getenv("SHARDING") == "ecs" {
+		member, members, err := aws.GetServiceTasks()
+		if err != nil {
+			return Shard{}, err
+		}
+		return Shard{
+			Index:   slices.Index(members, member),
+			Count:   len(members),
+			Member:  member,
+			Members: members,
+		}, nil
+	}
+
+	countValue := os.Getenv("SHARD_COUNT")
+	if countValue == "" {
+		return Shard{Index: 0, Count: 1}, nil
+	}
+	count, err := strconv.Atoi(countValue)
+	if err != nil || count < 1 {
+		return Shard{}, fmt.Errorf("invalid SHARD_COUNT %q", countValue)
+	}
+	index, err := strconv.Atoi(os.Getenv("SHARD_INDEX"))
+	if err != nil || index < 0 || index >= count {
+		return Shard{}, fmt.Errorf("invalid SHARD_INDEX %q for %d shards", os.Getenv("SHARD_INDEX"), count)
+	}
+	return Shard{Index: index, Count: count}, nil
+}
+
+// Equal reports whether two shards own the same keys.
+func (s Shard) Equal(other Shard) bool {
+	return s.Index == other.Index && s.Count == other.Count &&
+		s.Member == other.Member && slices.Equal(s.Members, other.Members)
+}
+
+// Owns reports whether key, such as a secret ARN, belongs to the shard. Keys
+// are assigned with rendezvous hashing over the shard numbers or member IDs,
+// so when a shard is added or removed only the keys it gains or owned move.
+// Numbered shards are their position, so with members the IDs are used.
+func (s Shard) Owns(key string) bool {
+	if len(s.Members) > 0 {
+		return s.ownsByMember(key)
+	}
+	if s.Count == 0 {
+		return false
+	}
+	if s.Count == 1 {
+		return true
+	}
+	owner, highest := 0, uint64(0)
+	for i := 0; i < s.Count; i++ {
+		if weight := rendezvousWeight(strconv.Itoa(i), key); i == 0 || weight > highest {
+			owner, highest = i, weight
+		}
+	}
+	return owner == s.Index
+}
+
+// ownsByMember reports whether the member with the highest weight for key is
+// the shard's member.
+func (s Shard) ownsByMember(key string) bool {
+	owner, highest := "", uint64(0)
+	for _, member := range s.Members {
+		if weight := rendezvousWeight(member, key); owner == "" || weight > highest {
+			owner, highest = member, weight
+		}
+	}
+	return owner == s.Member
+}
+
+// rendezvousWeight returns the weight of a shard for a key. The FNV hash is
+// finalized with the SplitMix64 mixer, as FNV alone spreads keys that only
+// differ in their last characters poorly.
+func rendezvousWeight(shard string, key string) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%s", shard, key)
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
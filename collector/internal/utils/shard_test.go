@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func secretKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("arn:aws:secretsmanager:us-west-2:111111111111:secret:db-%d", i)
+	}
+	return keys
+}
+
+// owners returns the shards owning each key, by shard position.
+func owners(shards []Shard, keys []string) map[string][]int {
+	result := make(map[string][]int)
+	for _, key := range keys {
+		for i, shard := range shards {
+			if shard.Owns(key) {
+				result[key] = append(result[key], i)
+			}
+		}
+	}
+	return result
+}
+
+func TestShardOwnsExactlyOnce(t *testing.T) {
+	members := []string{"task-a", "task-b", "task-c"}
+	tests := []struct {
+		name   string
+		shards []Shard
+	}{
+		{"single", []Shard{{Index: 0, Count: 1}}},
+		{"numbered", []Shard{{Index: 0, Count: 3}, {Index: 1, Count: 3}, {Index: 2, Count: 3}}},
+		{"members", []Shard{
+			{Member: "task-a", Members: members},
+			{Member: "task-b", Members: members},
+			{Member: "task-c", Members: members},
+		}},
+	}
+	keys := secretKeys(300)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := make([]int, len(tt.shards))
+			for key, owned := range owners(tt.shards, keys) {
+				if len(owned) != 1 {
+					t.Fatalf("key %s owned by %v, want exactly one shard", key, owned)
+				}
+				counts[owned[0]]++
+			}
+			for i, count := range counts {
+				if len(tt.shards) > 1 && count < len(keys)/len(tt.shards)/2 {
+					t.Errorf("shard %d owns %d of %d keys", i, count, len(keys))
+				}
+			}
+		})
+	}
+}
+
+func TestUnresolvedShardOwnsNothing(t *testing.T) {
+	for _, key := range secretKeys(10) {
+		if (Shard{}).Owns(key) {
+			t.Errorf("unresolved shard owns %s", key)
+		}
+	}
+}
+
+func TestShardReplacingMemberOnlyMovesItsKeys(t *testing.T) {
+	before := []string{"task-a", "task-b", "task-c"}
+	after := []string{"task-a", "task-c", "task-d"}
+	owner := func(members []string, key string) string {
+		for _, member := range members {
+			if (Shard{Member: member, Members: members}).Owns(key) {
+				return member
+			}
+		}
+		return ""
+	}
+	for _, key := range secretKeys(300) {
+		was, is := owner(before, key), owner(after, key)
+		if was != "task-b" && is != "task-d" && was != is {
+			t.Errorf("key %s moved from %s to %s", key, was, is)
+		}
+	}
+}
+
+func TestShardEqual(t *testing.T) {
+	a := Shard{Index: 0, Count: 2, Member: "task-a", Members: []string{"task-a", "task-b"}}
+	b := Shard{Index: 0, Count: 2, Member: "task-a", Members: []string{"task-a", "task-b"}}
+	c := Shard{Index: 0, Count: 2, Member: "task-a", Members: []string{"task-a", "task-c"}}
+	if !a.Equal(b) {
+		t.Error("identical shards are not equal")
+	}
+	if a.Equal(c) {
+		t.Error("shards with different members are equal")
+	}
+}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSpoolMaxBytes bounds the size of an endpoint's spool directory.
+	defaultSpoolMaxBytes = 256 << 20
+	// defaultSpoolMaxAge is how long spooled requests are kept. Older samples
+	// fall outside the out-of-order ingestion window and would be rejected.
+	defaultSpoolMaxAge = time.Hour
+	// segmentSuffix is the extension of spooled segments, each holding one
+	// snappy compressed write request.
+	segmentSuffix = ".snappy"
+)
+
+// spool stores write requests that failed to send on local disk, one segment
+// per request, and replays them in order once the endpoint recovers. The
+// samples keep their original timestamps.
+type spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mutex     sync.Mutex // guards writing and truncating segments
+	replaying sync.Mutex // held while a replay runs
+	sequence  uint64
+}
+
+// newSpool returns the spool of an endpoint in a subdirectory of SPOOL_DIR,
+// bounded by SPOOL_MAX_BYTES and SPOOL_MAX_AGE. It returns nil when spooling
+// is not configured.
+func newSpool(endpointName string) (*spool, error) {
+	baseDir := os.Getenv("SPOOL_DIR")
+	if baseDir == "" {
+		return nil, nil
+	}
+
+	s := &spool{
+		dir:      filepath.Join(baseDir, SanitizeLabelName(endpointName)),
+		maxBytes: defaultSpoolMaxBytes,
+		maxAge:   defaultSpoolMaxAge,
+	}
+	if value := os.Getenv("SPOOL_MAX_BYTES"); value != "" {
+		maxBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			return nil, fmt.Errorf("invalid SPOOL_MAX_BYTES %q", value)
+		}
+		s.maxBytes = maxBytes
+	}
+	if value := os.Getenv("SPOOL_MAX_AGE"); value != "" {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil || maxAge <= 0 {
+			return nil, fmt.Errorf("invalid SPOOL_MAX_AGE %q", value)
+		}
+		s.maxAge = maxAge
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return s, nil
+}
+
+// store writes a request to a new segment and removes the oldest segments
+// beyond the size bound.
+func (s *spool) store(body []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sequence++
+	name := fmt.Sprintf("%020d-%010d%s", time.Now().UnixNano(), s.sequence, segmentSuffix)
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		return fmt.Errorf("failed to spool request: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("failed to spool request: %w", err)
+	}
+	return s.truncate()
+}
+
+// replay sends the spooled segments, oldest first, and removes each one once
+// sent. It stops at the first failure to keep the order and then reports that
+// segments are still pending. Segments the endpoint rejects for good are
+// logged and dropped. Only one replay runs at a time; concurrent calls report
+// pending segments, so their requests are spooled behind the ones being
+// replayed and sent by the next replay.
+func (s *spool) replay(send func(body *bytes.Reader) error) (bool, error) {
+	if !s.replaying.TryLock() {
+		return true, nil
+	}
+	defer s.replaying.Unlock()
+
+	s.mutex.Lock()
+	segments, err := s.segments()
+	s.mutex.Unlock()
+	if err != nil {
+		return false, err
+	}
+	for _, segment := range segments {
+		path := filepath.Join(s.dir, segment.Name())
+		body, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			// Truncated since it was listed
+			continue
+		}
+		if err != nil {
+			return true, fmt.Errorf("failed to read spooled request: %w", err)
+		}
+		if err := send(bytes.NewReader(body)); err != nil {
+			if isRecoverable(err) {
+				return true, fmt.Errorf("failed to replay spooled request: %w", err)
+			}
+			// The endpoint will never accept this request, move on
+			slog.Warn("Dropping spooled request rejected by the endpoint", "segment", path, "error", err)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return true, fmt.Errorf("failed to remove spooled request: %w", err)
+		}
+	}
+	return false, nil
+}
+
+// truncate removes the oldest segments until the spool fits in maxBytes. The
+// caller must hold the mutex.
+func (s *spool) truncate() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, segment := range segments {
+		size += segment.Size()
+	}
+	for _, segment := range segments {
+		if size <= s.maxBytes {
+			break
+		}
+		// A segment being replayed may have been removed already
+		if err := os.Remove(filepath.Join(s.dir, segment.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove spooled request: %w", err)
+		}
+		slog.Warn("Dropping spooled request, spool is full", "segment", segment.Name(), "maxBytes", s.maxBytes)
+		size -= segment.Size()
+	}
+	return nil
+}
+
+// segments returns the spooled segments, oldest first. Segments past maxAge
+// are removed, their samples would be rejected.
+func (s *spool) segments() ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	var segments []os.FileInfo
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), segmentSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > s.maxAge {
+			slog.Warn("Dropping spooled request older than the maximum age", "segment", entry.Name(), "maxAge", s.maxAge)
+			_ = os.Remove(filepath.Join(s.dir, entry.Name()))
+			continue
+		}
+		segments = append(segments, info)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Name() < segments[j].Name()
+	})
+	return segments, nil
+}
@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func newTestSpool(t *testing.T, maxBytes int64) *spool {
+	t.Helper()
+	return &spool{dir: t.TempDir(), maxBytes: maxBytes, maxAge: time.Hour}
+}
+
+// spooled returns the bodies of the spooled segments, oldest first.
+func spooled(t *testing.T, s *spool) []string {
+	t.Helper()
+	segments, err := s.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bodies []string
+	for _, segment := range segments {
+		body, err := os.ReadFile(s.dir + "/" + segment.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, string(body))
+	}
+	return bodies
+}
+
+func TestSpoolReplay(t *testing.T) {
+	recoverable := errors.New("connection refused")
+	rejected := nonRecoverableError{errors.New("400 bad request")}
+
+	tests := []struct {
+		name        string
+		fail        map[string]error
+		wantSent    []string
+		wantPending bool
+		wantLeft    []string
+	}{
+		{
+			name:     "all sent in order",
+			wantSent: []string{"a", "b", "c"},
+		},
+		{
+			name:        "stops at recoverable error",
+			fail:        map[string]error{"b": recoverable},
+			wantSent:    []string{"a", "b"},
+			wantPending: true,
+			wantLeft:    []string{"b", "c"},
+		},
+		{
+			name:     "drops rejected request",
+			fail:     map[string]error{"b": rejected},
+			wantSent: []string{"a", "b", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSpool(t, 1<<20)
+			for _, body := range []string{"a", "b", "c"} {
+				if err := s.store([]byte(body)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var sent []string
+			pending, err := s.replay(func(body *bytes.Reader) error {
+				data, _ := io.ReadAll(body)
+				sent = append(sent, string(data))
+				return tt.fail[string(data)]
+			})
+			if pending != tt.wantPending || (err != nil) != tt.wantPending {
+				t.Errorf("replay() = %v, %v, want pending %v", pending, err, tt.wantPending)
+			}
+			if !equalStrings(sent, tt.wantSent) {
+				t.Errorf("sent %v, want %v", sent, tt.wantSent)
+			}
+			if left := spooled(t, s); !equalStrings(left, tt.wantLeft) {
+				t.Errorf("left %v, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestSpoolTruncate(t *testing.T) {
+	s := newTestSpool(t, 5)
+	for _, body := range []string{"aa", "bb", "cc"} {
+		if err := s.store([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if left := spooled(t, s); !equalStrings(left, []string{"bb", "cc"}) {
+		t.Errorf("left %v, want [bb cc]", left)
+	}
+}
+
+func TestSpoolDropsOldSegments(t *testing.T) {
+	s := newTestSpool(t, 1<<20)
+	if err := s.store([]byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	segments, _ := s.segments()
+	past := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(s.dir+"/"+segments[0].Name(), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if left := spooled(t, s); len(left) != 0 {
+		t.Errorf("left %v, want none", left)
+	}
+}
+
+func TestEndpointWriteReplaysFirst(t *testing.T) {
+	var mutex sync.Mutex
+	var received []int
+	up := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, body)
+		request := &prompb.WriteRequest{}
+		if err == nil {
+			err = request.Unmarshal(data)
+		}
+		if err != nil {
+			t.Errorf("invalid write request: %v", err)
+		}
+		received = append(received, len(request.Timeseries))
+	}))
+	defer server.Close()
+
+	e := &endpoint{config: &EndpointConfig{Name: "test", URL: server.URL}, spool: newTestSpool(t, 1<<20)}
+	series := func(n int) []prompb.TimeSeries {
+		ts := make([]prompb.TimeSeries, n)
+		for i := range ts {
+			ts[i] = prompb.TimeSeries{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}, Samples: []prompb.Sample{{Value: 1, Timestamp: int64(i)}}}
+		}
+		return ts
+	}
+
+	if err := e.write(series(1)); err == nil {
+		t.Fatal("write to a failing endpoint succeeded")
+	}
+	if err := e.write(series(2)); err == nil {
+		t.Fatal("write behind pending requests succeeded")
+	}
+	if left := spooled(t, e.spool); len(left) != 2 {
+		t.Fatalf("spooled %d requests, want 2", len(left))
+	}
+
+	mutex.Lock()
+	up = true
+	mutex.Unlock()
+	if err := e.write(series(3)); err != nil {
+		t.Fatalf("write after recovery failed: %v", err)
+	}
+	if !reflect.DeepEqual(received, []int{1, 2, 3}) {
+		t.Errorf("received requests of %v series, want [1 2 3]", received)
+	}
+	if left := spooled(t, e.spool); len(left) != 0 {
+		t.Errorf("left %d spooled requests", len(left))
+	}
+}
+
+func TestEndpointWriteQueuesBehindReplayInProgress(t *testing.T) {
+	var mutex sync.Mutex
+	var received []int
+	replaying := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		data, _ := snappy.Decode(nil, body)
+		request := &prompb.WriteRequest{}
+		if err := request.Unmarshal(data); err != nil {
+			t.Errorf("invalid write request: %v", err)
+		}
+		mutex.Lock()
+		received = append(received, len(request.Timeseries))
+		first := len(received) == 1
+		mutex.Unlock()
+		// Hold the replay of the spooled request until the next write was made
+		if first {
+			close(replaying)
+			<-release
+		}
+	}))
+	defer server.Close()
+
+	e := &endpoint{config: &EndpointConfig{Name: "test", URL: server.URL}, spool: newTestSpool(t, 1<<20)}
+	series := func(n int) []prompb.TimeSeries {
+		ts := make([]prompb.TimeSeries, n)
+		for i := range ts {
+			ts[i] = prompb.TimeSeries{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}, Samples: []prompb.Sample{{Value: 1, Timestamp: int64(i)}}}
+		}
+		return ts
+	}
+	body, err := encodeWriteRequestIntoProtoAndSnappy(&prompb.WriteRequest{Timeseries: series(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.spool.store(body); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- e.write(series(2))
+	}()
+	<-replaying
+	if err := e.write(series(3)); err == nil {
+		t.Error("write during a replay succeeded, want it spooled")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("write after replay failed: %v", err)
+	}
+	if left := spooled(t, e.spool); len(left) != 1 {
+		t.Fatalf("spooled %d requests, want the one written during the replay", len(left))
+	}
+
+	if err := e.write(series(4)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if !reflect.DeepEqual(received, []int{1, 2, 3, 4}) {
+		t.Errorf("received requests of %v series, want [1 2 3 4]", received)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
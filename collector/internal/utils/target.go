@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// identifierTag overrides the identifier of a database when set on its secret.
+const identifierTag = "database-collector:identifier"
+
+// engineFamilies maps the supported engines to the family of engines sharing
+// a collector.
+var engineFamilies = map[string]string{
+	"mysql":            "mysql",
+	"postgres":         "postgres",
+	"oracle":           "oracle",
+	"oracle-ee":        "oracle",
+	"custom-oracle-ee": "oracle",
+}
+
+// EngineFamily returns the family of an engine, e.g. oracle for oracle-ee, or
+// "" when the engine is not supported.
+func EngineFamily(engine string) string {
+	return engineFamilies[engine]
+}
+
+// Target describes the database a set of series belongs to. Region and
+// AccountID, when set, replace the collector's own region and accountId labels.
+type Target struct {
+	Identifier string
+	Engine     string
+	Region     string
+	AccountID  string
+	Labels     map[string]string
+}
+
+// NewTarget builds the target of a discovered secret from its ARN, tags and
+// value. The region and account are those of the secret.
+func NewTarget(secretARN string, tags map[string]string, secret map[string]interface{}) Target {
+	engine, _ := secret["engine"].(string)
+	target := Target{
+		Identifier: TargetIdentifier(tags, secret),
+		Engine:     engine,
+		Labels:     TargetLabels(tags, secret),
+	}
+	if parsed, err := arn.Parse(secretARN); err == nil {
+		target.Region = parsed.Region
+		target.AccountID = parsed.AccountID
+	}
+	return target
+}
+
+// TargetIdentifier returns the identifier of a database. It is taken from the
+// dbInstanceIdentifier or dbClusterIdentifier secret field, then from the
+// identifierTag tag and only then derived from the host.
+func TargetIdentifier(tags map[string]string, secret map[string]interface{}) string {
+	for _, key := range []string{"dbInstanceIdentifier", "dbClusterIdentifier"} {
+		if value, ok := secret[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	if value := tags[identifierTag]; value != "" {
+		return value
+	}
+	host, _ := secret["host"].(string)
+	return IdentifierFromHost(host)
+}
+
+// IdentifierFromHost returns the instance, cluster or proxy name from an RDS
+// endpoint such as mydb.abc123.us-west-2.rds.amazonaws.com. Any other host,
+// including IP addresses and custom DNS names, is returned unchanged.
+func IdentifierFromHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	for _, suffix := range []string{".rds.amazonaws.com", ".rds.amazonaws.com.cn"} {
+		if strings.HasSuffix(host, suffix) {
+			return strings.Split(host, ".")[0]
+		}
+	}
+	return host
+}
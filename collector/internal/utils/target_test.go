@@ -0,0 +1,62 @@
+package utils
+
+import "testing"
+
+func TestTargetIdentifier(t *testing.T) {
+	tests := []struct {
+		name   string
+		tags   map[string]string
+		secret map[string]interface{}
+		want   string
+	}{
+		{
+			name:   "instance identifier",
+			tags:   map[string]string{identifierTag: "tagged"},
+			secret: map[string]interface{}{"dbInstanceIdentifier": "orders", "host": "other.abc123.us-west-2.rds.amazonaws.com"},
+			want:   "orders",
+		},
+		{
+			name:   "cluster identifier",
+			secret: map[string]interface{}{"dbClusterIdentifier": "orders-cluster"},
+			want:   "orders-cluster",
+		},
+		{
+			name:   "tag",
+			tags:   map[string]string{identifierTag: "tagged"},
+			secret: map[string]interface{}{"host": "orders.abc123.us-west-2.rds.amazonaws.com"},
+			want:   "tagged",
+		},
+		{
+			name:   "rds host",
+			secret: map[string]interface{}{"host": "Orders.abc123.us-west-2.rds.amazonaws.com."},
+			want:   "orders",
+		},
+		{
+			name:   "custom host",
+			secret: map[string]interface{}{"host": "db.example.com"},
+			want:   "db.example.com",
+		},
+		{
+			name:   "ip address",
+			secret: map[string]interface{}{"host": "10.0.0.12"},
+			want:   "10.0.0.12",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TargetIdentifier(tt.tags, tt.secret); got != tt.want {
+				t.Errorf("TargetIdentifier() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTarget(t *testing.T) {
+	target := NewTarget("arn:aws:secretsmanager:eu-west-1:222222222222:secret:orders-AbCdEf",
+		map[string]string{"database-collector:label:team": "payments"},
+		map[string]interface{}{"engine": "postgres", "host": "orders.abc123.eu-west-1.rds.amazonaws.com"})
+	if target.Identifier != "orders" || target.Engine != "postgres" ||
+		target.Region != "eu-west-1" || target.AccountID != "222222222222" || target.Labels["team"] != "payments" {
+		t.Errorf("NewTarget() = %+v", target)
+	}
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	ioprometheusclient "github.com/prometheus/client_model/go"
+)
+
+// TargetUpMetricName reports whether a target could be scraped. It is sent
+// for every discovered target, including those whose collector failed to
+// initialise, so unreachable databases can be alerted on the same way for
+// all engines.
+const TargetUpMetricName = "database_collector_target_up"
+
+// engineUpMetrics are the metrics each exporter uses to report whether it
+// could connect to the database.
+var engineUpMetrics = map[string]string{
+	"mysql":            "mysql_up",
+	"postgres":         "pg_up",
+	"oracle":           "oracledb_up",
+	"oracle-ee":        "oracledb_up",
+	"custom-oracle-ee": "oracledb_up",
+}
+
+// TargetUp returns 1 if the engine's exporter reported the database as up in
+// the gathered metric families, or, for exporters without an up metric, if
+// gathering succeeded. It returns 0 otherwise.
+func TargetUp(metricFamilies []*ioprometheusclient.MetricFamily, engine string, gatherErr error) float64 {
+	if name, ok := engineUpMetrics[engine]; ok {
+		for _, mf := range metricFamilies {
+			if mf.GetName() != name {
+				continue
+			}
+			// up metrics are either gauges or untyped, the other value is 0
+			for _, m := range mf.Metric {
+				if m.GetGauge().GetValue()+m.GetUntyped().GetValue() == 0 {
+					return 0
+				}
+			}
+			return 1
+		}
+	}
+	if gatherErr != nil || len(metricFamilies) == 0 {
+		return 0
+	}
+	return 1
+}
+
+// TargetUpMetricFamily returns the TargetUpMetricName gauge with the given
+// value. The identifier and engine labels are added on export.
+func TargetUpMetricFamily(up float64) *ioprometheusclient.MetricFamily {
+	return &ioprometheusclient.MetricFamily{
+		Name: proto.String(TargetUpMetricName),
+		Help: proto.String("Whether the database collector could scrape the target (1) or not (0)."),
+		Type: ioprometheusclient.MetricType_GAUGE.Enum(),
+		Metric: []*ioprometheusclient.Metric{{
+			Gauge: &ioprometheusclient.Gauge{Value: proto.Float64(up)},
+		}},
+	}
+}
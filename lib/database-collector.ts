@@ -14,26 +14,95 @@ import {ContainerImage} from 'aws-cdk-lib/aws-ecs';
 import {StandardFargateService, StandardFargateCluster,} from "truemark-cdk-lib/aws-ecs";
 import {ExtendedGoFunction} from "truemark-cdk-lib/aws-lambda";
 import {Runtime} from "aws-cdk-lib/aws-lambda";
-import {Rule} from "aws-cdk-lib/aws-events";
+import {Match, Rule, Schedule} from "aws-cdk-lib/aws-events";
 import {LambdaFunction} from "aws-cdk-lib/aws-events-targets";
+import {AttributeType, BillingMode, Table} from "aws-cdk-lib/aws-dynamodb";
 import {Duration} from "aws-cdk-lib";
 import {Stack} from "aws-cdk-lib";
 
 
+// splitList splits a comma-separated context value, ignoring blanks around
+// and between the entries
+function splitList(value: string): string[] {
+  return value.split(',').map(entry => entry.trim()).filter(entry => entry !== '')
+}
+
 export interface DatabaseCollectorProps {
 }
 
 export class DatabaseCollector extends Construct {
   private prometheusUrl = this.node.tryGetContext('prometheusUrl')
+  private prometheusRoleArn = this.node.tryGetContext('prometheusRoleArn') || ''
+  private prometheusExternalId = this.node.tryGetContext('prometheusExternalId') || ''
+  private discoveryRegions = this.node.tryGetContext('discoveryRegions') || ''
+  private discoveryRoleArns = this.node.tryGetContext('discoveryRoleArns') || ''
+  private discoveryExternalId = this.node.tryGetContext('discoveryExternalId') || ''
+  private oracleWalletBuckets = this.node.tryGetContext('oracleWalletBuckets') || ''
+  private eventsWebhookConfig = this.node.tryGetContext('eventsWebhookConfig') || ''
+  private assumePrometheusRolePolicy(): PolicyStatement | undefined {
+    if (!this.prometheusRoleArn) {
+      return undefined
+    }
+    return new PolicyStatement({
+      actions: ["sts:AssumeRole"],
+      resources: [this.prometheusRoleArn]
+    })
+  }
+  // discoveryPolicies allow listing and reading the tagged secrets, also in
+  // the accounts of the discovery roles
+  private discoveryPolicies(): PolicyStatement[] {
+    const policies = [
+      new PolicyStatement({
+        actions: [
+          "secretsmanager:DescribeSecret",
+          "secretsmanager:ListSecrets"
+        ],
+        resources: ["*"]
+      }),
+      new PolicyStatement({
+        actions: [
+          "secretsmanager:GetSecretValue",
+        ],
+        resources: ["*"],
+        conditions: {
+          "StringEquals": {
+            "aws:ResourceTag/database-collector:enabled": "true"
+          }
+        }
+      })
+    ]
+    if (splitList(this.discoveryRoleArns).length > 0) {
+      policies.push(new PolicyStatement({
+        actions: ["sts:AssumeRole"],
+        resources: splitList(this.discoveryRoleArns)
+      }))
+    }
+    return policies
+  }
   private buildAndDeployRDSEventsCollector() {
     const role = new Role(this, "Role", {
       assumedBy: new ServicePrincipal("lambda.amazonaws.com")
     })
     role.addManagedPolicy({managedPolicyArn: "arn:aws:iam::aws:policy/CloudWatchFullAccessV2"})
     role.addManagedPolicy({managedPolicyArn: "arn:aws:iam::aws:policy/AmazonPrometheusRemoteWriteAccess"})
+    const stateTable = new Table(this, 'EventsStateTable', {
+      partitionKey: {name: 'id', type: AttributeType.STRING},
+      billingMode: BillingMode.PAY_PER_REQUEST,
+      // Webhook dedupe and event delivery leases are deleted once expired
+      timeToLiveAttribute: 'expires',
+    })
+    // Event states are listed from this index on every invocation instead of scanning the table
+    stateTable.addGlobalSecondaryIndex({
+      indexName: 'kind-id',
+      partitionKey: {name: 'kind', type: AttributeType.STRING},
+      sortKey: {name: 'id', type: AttributeType.STRING},
+    })
+    stateTable.grantReadWriteData(role)
+    // Only service events, not e.g. AWS API Call via CloudTrail from the same sources
     const rdsEventRule = new Rule(this, 'RDSEventRule', {
       eventPattern: {
-        source: ['aws.rds']
+        source: ['aws.rds', 'aws.dms', 'aws.elasticache'],
+        detailType: Match.anyOf(Match.prefix('RDS '), Match.prefix('DMS '), Match.prefix('ElastiCache ')),
       }
     })
     const eventsFn = new ExtendedGoFunction(this, 'EventsLambda', {
@@ -41,6 +110,14 @@ export class DatabaseCollector extends Construct {
       memorySize: 1024,
       environment: {
         PROMETHEUS_REMOTE_WRITE_URL: this.prometheusUrl,
+        PROMETHEUS_REMOTE_WRITE_ROLE_ARN: this.prometheusRoleArn,
+        PROMETHEUS_REMOTE_WRITE_EXTERNAL_ID: this.prometheusExternalId,
+        EVENTS_STATE_TABLE: stateTable.tableName,
+        EVENTS_TARGET_CATALOGUE: "true",
+        EVENTS_WEBHOOK_CONFIG: this.eventsWebhookConfig,
+        DISCOVERY_REGIONS: this.discoveryRegions,
+        DISCOVERY_ROLE_ARNS: this.discoveryRoleArns,
+        DISCOVERY_EXTERNAL_ID: this.discoveryExternalId
       },
       timeout: Duration.seconds(300),
       runtime: Runtime.PROVIDED_AL2023,
@@ -49,11 +126,27 @@ export class DatabaseCollector extends Construct {
         createDeployment: false,
       },
     })
+    const assumeRolePolicy = this.assumePrometheusRolePolicy()
+    if (assumeRolePolicy) {
+      role.addToPolicy(assumeRolePolicy)
+    }
+    for (const policy of this.discoveryPolicies()) {
+      role.addToPolicy(policy)
+    }
     rdsEventRule.addTarget(new LambdaFunction(eventsFn))
+    // Push the stored state every minute, so state gauges stay within the
+    // query lookback between events
+    const stateRule = new Rule(this, 'EventsStateRule', {
+      schedule: Schedule.rate(Duration.minutes(1))
+    })
+    stateRule.addTarget(new LambdaFunction(eventsFn))
   }
   private buildAndDeployECSFargate() {
     const subnetIds = this.node.tryGetContext('subnetIds').split(',');
     const vpcName = this.node.tryGetContext('vpcName') || 'services';
+    const haCluster = this.node.tryGetContext('haCluster') || '';
+    const desiredCount = Number(this.node.tryGetContext('desiredCount') || (haCluster ? 2 : 1));
+    const sharded = desiredCount > 1 && !haCluster;
 
 
     const asset = new DockerImageAsset(this, 'DatabaseCollectorContainerImage', {
@@ -70,39 +163,54 @@ export class DatabaseCollector extends Construct {
       cpu: 1024,
       cluster,
       memoryLimitMiB: 2048,
-      desiredCount: 1,
+      desiredCount: desiredCount,
       environment: {
         RUN_MODE: "CRON",
+        SPOOL_DIR: "/tmp/database-collector-spool",
+        SHARDING: sharded ? "ecs" : "",
+        HA_CLUSTER: haCluster,
         PROMETHEUS_REMOTE_WRITE_URL: this.prometheusUrl,
+        PROMETHEUS_REMOTE_WRITE_ROLE_ARN: this.prometheusRoleArn,
+        PROMETHEUS_REMOTE_WRITE_EXTERNAL_ID: this.prometheusExternalId,
         AWS_REGION: Stack.of(this).region,
-        AWS_ACCOUNT_ID: Stack.of(this).account
+        AWS_ACCOUNT_ID: Stack.of(this).account,
+        DISCOVERY_REGIONS: this.discoveryRegions,
+        DISCOVERY_ROLE_ARNS: this.discoveryRoleArns,
+        DISCOVERY_EXTERNAL_ID: this.discoveryExternalId
       },
       vpcSubnets: {
         subnetFilters: [SubnetFilter.byIds(subnetIds)]
       }
     })
-    service.taskDefinition.addToTaskRolePolicy(new PolicyStatement({
-      actions: [
-        "secretsmanager:DescribeSecret",
-        "secretsmanager:ListSecrets"
-      ],
-      resources: ["*"]
-    }))
-    service.taskDefinition.addToTaskRolePolicy(new PolicyStatement({
-      actions: [
-        "secretsmanager:GetSecretValue",
-      ],
-      resources: ["*"],
-      conditions: {
-        "StringEquals": {
-          "aws:ResourceTag/database-collector:enabled": "true"
-        }
-      }
-    }))
+    for (const policy of this.discoveryPolicies()) {
+      service.taskDefinition.addToTaskRolePolicy(policy)
+    }
+    if (splitList(this.oracleWalletBuckets).length > 0) {
+      service.taskDefinition.addToTaskRolePolicy(new PolicyStatement({
+        actions: ["s3:GetObject", "s3:GetBucketLocation"],
+        resources: splitList(this.oracleWalletBuckets).flatMap((bucket: string) => [
+          `arn:aws:s3:::${bucket}`,
+          `arn:aws:s3:::${bucket}/*`
+        ])
+      }))
+    }
     service.taskDefinition.taskRole.addManagedPolicy(ManagedPolicy.fromManagedPolicyArn(
       this,
       'PrometheusRemoteWrite',
       'arn:aws:iam::aws:policy/AmazonPrometheusRemoteWriteAccess'))
+    const assumeRolePolicy = this.assumePrometheusRolePolicy()
+    if (assumeRolePolicy) {
+      service.taskDefinition.addToTaskRolePolicy(assumeRolePolicy)
+    }
+    if (sharded) {
+      service.taskDefinition.addToTaskRolePolicy(new PolicyStatement({
+        actions: [
+          "ecs:DescribeTasks",
+          "ecs:ListTasks"
+        ],
+        resources: ["*"]
+      }))
+    }
   }
 
   constructor(scope: Construct, id: string, props: DatabaseCollectorProps) {